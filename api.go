@@ -1,12 +1,27 @@
 package rm
 
 import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
 	"path"
+	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	rmApi "github.com/juruen/rmapi/api"
+	rmArchive "github.com/juruen/rmapi/archive"
 	rmLog "github.com/juruen/rmapi/log"
 	rmModel "github.com/juruen/rmapi/model"
 	rmTransport "github.com/juruen/rmapi/transport"
@@ -17,15 +32,64 @@ var ErrNotFound = errors.New("not found")
 var ErrAlreadyExists = errors.New("already exists")
 var ErrApi = errors.New("cloud API call error")
 
+// Document storage host, overridable via RMAPI_DOC as in rmapi
+var docHost = "https://document-storage-production-dot-remarkable-production.appspot.com"
+
+// Authentication host, overridable via RMAPI_AUTH as in rmapi
+var authHost = "https://my.remarkable.com"
+
+const (
+	listDocsPath      = "/document-storage/json/2/docs"
+	uploadRequestPath = "/document-storage/json/2/upload/request"
+	updateStatusPath  = "/document-storage/json/2/upload/update-status"
+	newDevicePath     = "/token/json/2/device/new"
+	newUserPath       = "/token/json/2/user/new"
+	deviceDesc        = "desktop-linux"
+)
+
+func init() {
+	if host := os.Getenv("RMAPI_DOC"); len(host) > 0 {
+		docHost = host
+	}
+	if host := os.Getenv("RMAPI_AUTH"); len(host) > 0 {
+		authHost = host
+	}
+}
+
+// Connection is safe for concurrent use. The user token is renewed
+// shortly before it expires; calls already in flight keep using the
+// previous one, still valid. Names are checked against the local
+// file tree only, so concurrent uploads into the same directory
+// under the same name might both get it.
 type Connection struct {
-	apiCtx *rmApi.ApiCtx
+	// mu guards the API context and token renewal
+	mu          sync.RWMutex
+	apiCtx      *rmApi.ApiCtx
+	deviceToken string
+	expiry      time.Time
+	retryAt     time.Time
+	refreshing  bool
+	onRefresh   func(userToken string, err error)
+	// tree guards the file tree, shared by all API contexts; it's
+	// never held across cloud requests
+	tree sync.RWMutex
+}
+
+type ConnectionOpt func(*Connection)
+
+// OnRefresh sets a function called whenever the user token is
+// renewed, or its renewal fails.
+func OnRefresh(f func(userToken string, err error)) ConnectionOpt {
+	return func(c *Connection) {
+		c.onRefresh = f
+	}
 }
 
 type Auth struct {
 	auth *rmModel.AuthTokens
 }
 
-func NewConnection(deviceToken, userToken string) (*Connection, error) {
+func NewConnection(deviceToken, userToken string, opts ...ConnectionOpt) (*Connection, error) {
 	rmLog.InitLog() // TODO fix upstream
 	if len(deviceToken) <= 0 {
 		return nil, errors.New("empty reMarkable device token")
@@ -36,68 +100,675 @@ func NewConnection(deviceToken, userToken string) (*Connection, error) {
 	auth := rmModel.AuthTokens{DeviceToken: deviceToken, UserToken: userToken}
 	transport := rmTransport.CreateHttpClientCtx(auth)
 	apiCtx, err := rmApi.CreateApiCtx(&transport)
-	return &Connection{apiCtx}, err
+	conn := &Connection{
+		apiCtx:      apiCtx,
+		deviceToken: deviceToken,
+		expiry:      tokenExpiry(userToken),
+	}
+	for _, opt := range opts {
+		opt(conn)
+	}
+	return conn, err
 }
 
 func NewUserToken(deviceToken string) (string, error) {
-	auth := rmModel.AuthTokens{DeviceToken: deviceToken, UserToken: ""}
-	conn := rmTransport.CreateHttpClientCtx(auth)
+	return NewUserTokenContext(context.Background(), deviceToken)
+}
+
+// NewUserTokenContext is NewUserToken, aborting the request once
+// ctx is done.
+func NewUserTokenContext(ctx context.Context, deviceToken string) (string, error) {
+	conn := rmTransport.CreateHttpClientCtx(rmModel.AuthTokens{})
 	resp := rmTransport.BodyString{}
-	err := conn.Post(rmTransport.DeviceBearer, "https://my.remarkable.com/token/json/2/user/new", nil, &resp)
+	err := send(ctx, conn.Client, http.MethodPost, authHost+newUserPath, deviceToken, nil, &resp)
 	if err != nil {
 		return "", fmt.Errorf("failed to create a new reMarkable user token: %w", err)
 	}
 	return resp.Content, nil
 }
 
+// RegisterDevice registers a new device with the one-time code
+// obtained from https://my.remarkable.com/device/desktop/connect,
+// returning its device token.
+func RegisterDevice(code string) (string, error) {
+	return RegisterDeviceContext(context.Background(), code)
+}
+
+// RegisterDeviceContext is RegisterDevice, aborting the request once
+// ctx is done.
+func RegisterDeviceContext(ctx context.Context, code string) (string, error) {
+	code = strings.TrimSpace(code)
+	if len(code) <= 0 {
+		return "", errors.New("empty reMarkable one-time code")
+	}
+	deviceID, err := newUUID()
+	if err != nil {
+		return "", err
+	}
+	conn := rmTransport.CreateHttpClientCtx(rmModel.AuthTokens{})
+	req := rmModel.DeviceTokenRequest{Code: code, DeviceDesc: deviceDesc, DeviceId: deviceID}
+	resp := rmTransport.BodyString{}
+	if err := send(ctx, conn.Client, http.MethodPost, authHost+newDevicePath, "", req, &resp); err != nil {
+		return "", fmt.Errorf("failed to register reMarkable device: %w", err)
+	}
+	if len(resp.Content) <= 0 {
+		return "", fmt.Errorf("failed to register reMarkable device: empty device token: %w", ErrApi)
+	}
+	return resp.Content, nil
+}
+
+// newUUID returns a random (version 4) UUID.
+func newUUID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// MkDir creates the target directory along with any missing parent,
+// failing with ErrAlreadyExists if a path component is a document.
 func (s *Connection) MkDir(target string) error {
-	target = strings.Trim(strings.TrimSpace(target), "/")
-	// Check if directory already exists
-	node, err := s.apiCtx.Filetree.NodeByPath(target, s.apiCtx.Filetree.Root())
+	return s.MkDirContext(context.Background(), target)
+}
+
+// MkDirContext is MkDir, giving up before the next directory is
+// created once ctx is done.
+func (s *Connection) MkDirContext(ctx context.Context, target string) error {
+	target = cleanPath(target)
+	tree := s.api().Filetree
+	s.tree.RLock()
+	parentNode := tree.Root()
+	s.tree.RUnlock()
+	current := ""
+	for _, name := range strings.Split(target, "/") {
+		if len(name) <= 0 {
+			continue
+		}
+		current = path.Join(current, name)
+		// Check if directory already exists
+		s.tree.RLock()
+		node, err := tree.NodeByPath(name, parentNode)
+		s.tree.RUnlock()
+		if err == nil {
+			if !node.IsDirectory() {
+				return fmt.Errorf("destination path %s: %w", current, ErrAlreadyExists)
+			}
+			parentNode = node
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		// Create directory from parent node
+		parentID := parentNode.Id()
+		if parentNode.IsRoot() {
+			parentID = ""
+		}
+		document, err := s.api().CreateDir(parentID, name)
+		if err != nil {
+			return fmt.Errorf("failed to create directory %s: %s: %w", current, err, ErrApi)
+		}
+		s.tree.Lock()
+		tree.AddDocument(document)
+		parentNode = tree.NodeById(document.ID)
+		s.tree.Unlock()
+		if parentNode == nil {
+			return fmt.Errorf("directory %s: %w", current, ErrNotFound)
+		}
+	}
+	return nil
+}
+
+// ConflictPolicy tells Put what to do when the destination
+// directory already holds a document with the same name.
+type ConflictPolicy int
+
+const (
+	// Skip leaves the existing document untouched
+	Skip ConflictPolicy = iota
+	// Overwrite replaces the content of the existing document,
+	// preserving its ID
+	Overwrite
+	// Rename uploads the document under a new name with a
+	// numeric suffix
+	Rename
+)
+
+var conflictPolicies = map[ConflictPolicy]string{
+	Skip:      "skip",
+	Overwrite: "overwrite",
+	Rename:    "rename",
+}
+
+func (p ConflictPolicy) String() string {
+	return conflictPolicies[p]
+}
+
+func ParseConflictPolicy(s string) (ConflictPolicy, error) {
+	for p, name := range conflictPolicies {
+		if name == s {
+			return p, nil
+		}
+	}
+	return Skip, fmt.Errorf("unknown conflict policy %q (expected skip, overwrite or rename)", s)
+}
+
+type putOptions struct {
+	Conflict ConflictPolicy
+	Name     string
+}
+
+type PutOpt func(*putOptions)
+
+// WithName sets the visible name of the uploaded document, which
+// otherwise is derived from the source file name.
+func WithName(name string) PutOpt {
+	return func(o *putOptions) {
+		o.Name = name
+	}
+}
+
+func OnConflict(policy ConflictPolicy) PutOpt {
+	return func(o *putOptions) {
+		o.Conflict = policy
+	}
+}
+
+// Put uploads srcName into destDir. When a document with the same
+// name already exists, the outcome depends on the conflict policy:
+// with Skip (the default) the existing entry is returned along with
+// ErrAlreadyExists.
+func (s *Connection) Put(srcName, destDir string, opts ...PutOpt) (*Entry, error) {
+	return s.PutContext(context.Background(), srcName, destDir, opts...)
+}
+
+// PutContext is Put, aborting the upload once ctx is done.
+func (s *Connection) PutContext(ctx context.Context, srcName, destDir string, opts ...PutOpt) (*Entry, error) {
+	o := &putOptions{Conflict: Skip}
+	for _, f := range opts {
+		f(o)
+	}
+	destDir = cleanPath(destDir)
+	docName, ext := rmUtil.DocPathToName(srcName)
+	if len(docName) <= 0 || !rmUtil.IsFileTypeSupported(ext) {
+		return nil, fmt.Errorf("unsupported file %s", srcName)
+	}
+	if len(o.Name) > 0 {
+		docName = o.Name
+	}
+
+	tree := s.api().Filetree
+	s.tree.RLock()
+	destNode, err := tree.NodeByPath(destDir, tree.Root())
+	if err != nil || destNode.IsFile() {
+		s.tree.RUnlock()
+		return nil, fmt.Errorf("destination directory %s: %w", destDir, ErrNotFound)
+	}
+	parentID := destNode.Id()
+	if destNode.IsRoot() {
+		parentID = ""
+	}
+	node, err := tree.NodeByPath(docName, destNode)
 	if err == nil {
-		if !node.IsDirectory() {
-			return fmt.Errorf("destination path %s: %w", target, ErrAlreadyExists)
+		switch {
+		case o.Conflict == Overwrite && node.IsFile():
+			req := rmModel.UploadDocumentRequest{ID: node.Id(), Type: rmModel.DocumentType, Version: node.Version() + 1}
+			s.tree.RUnlock()
+			document, err := s.upload(ctx, req, parentID, docName, srcName)
+			if err != nil && ctx.Err() != nil {
+				return nil, fmt.Errorf("replacement of document %s aborted: %w", docName, ctx.Err())
+			} else if err != nil {
+				return nil, fmt.Errorf("failed to replace document %s: %s: %w", docName, err, ErrApi)
+			}
+			s.tree.Lock()
+			defer s.tree.Unlock()
+			node.Document = document
+			entry := s.newEntry(node)
+			return &entry, nil
+		case o.Conflict == Rename:
+			docName = s.freeName(destNode, docName)
+		default:
+			defer s.tree.RUnlock()
+			entry := s.newEntry(node)
+			return &entry, fmt.Errorf("destination file %s: %w", docName, ErrAlreadyExists)
+		}
+	}
+	s.tree.RUnlock()
+
+	req := rmModel.CreateUploadDocumentRequest("", rmModel.DocumentType)
+	document, err := s.upload(ctx, req, parentID, docName, srcName)
+	if err != nil && ctx.Err() != nil {
+		return nil, fmt.Errorf("upload of file %s aborted: %w", srcName, ctx.Err())
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to upload file %s: %s: %w", srcName, err, ErrApi)
+	}
+	s.tree.Lock()
+	defer s.tree.Unlock()
+	tree.AddDocument(*document)
+	entry := s.newEntry(tree.NodeById(document.ID))
+	return &entry, nil
+}
+
+// freeName returns name, suffixed if needed to not clash
+// with the children of dir. It must be called with s.tree held.
+func (s *Connection) freeName(dir *rmModel.Node, name string) string {
+	candidate := name
+	for i := 2; ; i++ {
+		if _, err := s.api().Filetree.NodeByPath(candidate, dir); err != nil {
+			return candidate
+		}
+		candidate = fmt.Sprintf("%s (%d)", name, i)
+	}
+}
+
+// upload mirrors rmapi's document upload, letting the caller choose
+// the document ID, version and visible name so that existing
+// documents can be replaced in place.
+func (s *Connection) upload(ctx context.Context, req rmModel.UploadDocumentRequest, parentID, name, srcName string) (*rmModel.Document, error) {
+	client := s.api().Http
+	rsp := []rmModel.UploadDocumentResponse{}
+	if err := put(ctx, client, docHost+uploadRequestPath, req, &rsp); err != nil {
+		return nil, err
+	}
+	if len(rsp) != 1 || !rsp[0].Success {
+		msg := "empty response"
+		if len(rsp) > 0 {
+			msg = rsp[0].Message
+		}
+		return nil, fmt.Errorf("upload request refused: %s", msg)
+	}
+	zipPath, err := rmArchive.CreateZipDocument(req.ID, srcName)
+	if err != nil {
+		return nil, err
+	}
+	if zipPath != srcName {
+		defer os.Remove(zipPath)
+	}
+	f, err := os.Open(zipPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if err := put(ctx, client, rsp[0].BlobURLPut, f, nil); err != nil {
+		return nil, err
+	}
+	meta := rmModel.MetadataDocument{
+		ID:             req.ID,
+		Parent:         parentID,
+		VissibleName:   name,
+		Type:           rmModel.DocumentType,
+		Version:        req.Version,
+		ModifiedClient: time.Now().UTC().Format(time.RFC3339Nano),
+	}
+	if err := put(ctx, client, docHost+updateStatusPath, meta, nil); err != nil {
+		return nil, err
+	}
+	document := meta.ToDocument()
+	document.Version = req.Version
+	return &document, nil
+}
+
+// put mirrors rmapi's authenticated PUT requests.
+func put(ctx context.Context, client *rmTransport.HttpClientCtx, target string, body, res interface{}) error {
+	return send(ctx, client.Client, http.MethodPut, target, client.Tokens.UserToken, body, res)
+}
+
+// send mirrors rmapi's requests, which can't be cancelled, with
+// token as bearer. body is either an io.Reader or marshalled to
+// JSON; the response is copied into res if it's an io.Writer, read
+// as is if it's a *rmTransport.BodyString, unmarshalled from JSON
+// otherwise, or discarded if res is nil.
+func send(ctx context.Context, client *http.Client, method, target, token string, body, res interface{}) error {
+	r, ok := body.(io.Reader)
+	if !ok && (body != nil || method != http.MethodGet) {
+		content, err := json.Marshal(body)
+		if err != nil {
+			return err
 		}
+		r = bytes.NewReader(content)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, r)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", strings.TrimSpace("Bearer "+token))
+	req.Header.Set("User-Agent", rmTransport.RmapiUserAGent)
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized:
+		return rmTransport.UnAuthorizedError
+	default:
+		return fmt.Errorf("request failed with status %d", resp.StatusCode)
+	}
+	switch res := res.(type) {
+	case nil:
 		return nil
+	case io.Writer:
+		_, err := io.Copy(res, resp.Body)
+		return err
+	case *rmTransport.BodyString:
+		content, err := ioutil.ReadAll(resp.Body)
+		res.Content = string(content)
+		return err
+	default:
+		return json.NewDecoder(resp.Body).Decode(res)
+	}
+}
+
+type Entry struct {
+	ID       string
+	Name     string
+	Path     string
+	IsDir    bool
+	Version  int
+	Modified time.Time
+}
+
+func cleanPath(p string) string {
+	return strings.Trim(strings.TrimSpace(p), "/")
+}
+
+// newEntry must be called with s.tree held.
+func (s *Connection) newEntry(node *rmModel.Node) Entry {
+	p, _ := s.api().Filetree.NodeToPath(node)
+	modified, _ := node.LastModified()
+	return Entry{
+		ID:       node.Id(),
+		Name:     node.Name(),
+		Path:     "/" + cleanPath(p),
+		IsDir:    node.IsDirectory(),
+		Version:  node.Version(),
+		Modified: modified,
 	}
-	// Ensure that target's parent directory exists
-	parentDir := path.Dir(target)
-	newDir := path.Base(target)
-	parentNode, err := s.apiCtx.Filetree.NodeByPath(parentDir, s.apiCtx.Filetree.Root())
-	if err != nil || parentNode.IsFile() {
-		return fmt.Errorf("parent directory %s: %w", parentDir, ErrNotFound)
+}
+
+// node and dirNode must be called with s.tree held.
+func (s *Connection) node(target string) (*rmModel.Node, error) {
+	target = cleanPath(target)
+	node, err := s.api().Filetree.NodeByPath(target, s.api().Filetree.Root())
+	if err != nil {
+		return nil, fmt.Errorf("path %s: %w", target, ErrNotFound)
 	}
-	// Create directory from parent node
-	parentID := parentNode.Id()
-	if parentNode.IsRoot() {
-		parentID = ""
+	return node, nil
+}
+
+func (s *Connection) dirNode(target string) (*rmModel.Node, error) {
+	node, err := s.node(target)
+	if err != nil {
+		return nil, err
+	}
+	if node.IsFile() {
+		return nil, fmt.Errorf("directory %s: %w", cleanPath(target), ErrNotFound)
+	}
+	return node, nil
+}
+
+func (s *Connection) List(dir string) ([]Entry, error) {
+	s.tree.RLock()
+	defer s.tree.RUnlock()
+	node, err := s.dirNode(dir)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]Entry, 0, len(node.Children))
+	for _, child := range node.Children {
+		entries = append(entries, s.newEntry(child))
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].IsDir != entries[j].IsDir {
+			return entries[i].IsDir
+		}
+		return entries[i].Name < entries[j].Name
+	})
+	return entries, nil
+}
+
+func (s *Connection) Stat(target string) (*Entry, error) {
+	s.tree.RLock()
+	defer s.tree.RUnlock()
+	node, err := s.node(target)
+	if err != nil {
+		return nil, err
+	}
+	entry := s.newEntry(node)
+	return &entry, nil
+}
+
+// StatID returns the document or directory identified by id.
+func (s *Connection) StatID(id string) (*Entry, error) {
+	s.tree.RLock()
+	defer s.tree.RUnlock()
+	node := s.api().Filetree.NodeById(id)
+	if node == nil {
+		return nil, fmt.Errorf("id %s: %w", id, ErrNotFound)
+	}
+	entry := s.newEntry(node)
+	return &entry, nil
+}
+
+// move must be called with s.tree read locked, which it releases.
+func (s *Connection) move(node, destNode *rmModel.Node, name string) error {
+	if node.IsRoot() {
+		s.tree.RUnlock()
+		return fmt.Errorf("cannot move root directory")
+	}
+	for n := destNode; n != nil; n = n.Parent {
+		if n == node {
+			s.tree.RUnlock()
+			return fmt.Errorf("cannot move %s into itself", node.Name())
+		}
 	}
-	document, err := s.apiCtx.CreateDir(parentID, newDir)
+	if existing, err := destNode.FindByName(name); err == nil && existing != node {
+		s.tree.RUnlock()
+		return fmt.Errorf("destination %s: %w", name, ErrAlreadyExists)
+	}
+	oldName := node.Name()
+	s.tree.RUnlock()
+	apiCtx := s.api()
+	moved, err := apiCtx.MoveEntry(node, destNode, name)
 	if err != nil {
-		return fmt.Errorf("failed to create directory %s: %s: %w", newDir, err, ErrApi)
+		return fmt.Errorf("failed to move %s: %s: %w", oldName, err, ErrApi)
 	}
-	s.apiCtx.Filetree.AddDocument(document)
+	s.tree.Lock()
+	defer s.tree.Unlock()
+	apiCtx.Filetree.MoveNode(node, moved)
+	node.Document.Parent = moved.Document.Parent
 	return nil
 }
 
-func (s *Connection) Put(srcName, destDir string) error {
-	destDir = strings.Trim(strings.TrimSpace(destDir), "/")
-	docName, _ := rmUtil.DocPathToName(srcName)
+func (s *Connection) Move(src, destDir string) error {
+	s.tree.RLock()
+	node, err := s.node(src)
+	if err != nil {
+		s.tree.RUnlock()
+		return err
+	}
+	destNode, err := s.dirNode(destDir)
+	if err != nil {
+		s.tree.RUnlock()
+		return err
+	}
+	return s.move(node, destNode, node.Name())
+}
 
-	destNode, err := s.apiCtx.Filetree.NodeByPath(destDir, s.apiCtx.Filetree.Root())
-	if err != nil || destNode.IsFile() {
-		return fmt.Errorf("destination directory %s: %w", destDir, ErrNotFound)
+func (s *Connection) Rename(target, name string) error {
+	if len(name) <= 0 || strings.Contains(name, "/") {
+		return fmt.Errorf("invalid name: %q", name)
+	}
+	s.tree.RLock()
+	node, err := s.node(target)
+	if err != nil {
+		s.tree.RUnlock()
+		return err
 	}
+	if node.IsRoot() {
+		s.tree.RUnlock()
+		return fmt.Errorf("cannot rename root directory")
+	}
+	return s.move(node, node.Parent, name)
+}
+
+func (s *Connection) Delete(target string) error {
+	s.tree.RLock()
+	node, err := s.node(target)
+	if err == nil && node.IsRoot() {
+		err = fmt.Errorf("cannot delete root directory")
+	} else if err == nil && node.IsDirectory() && len(node.Children) > 0 {
+		err = fmt.Errorf("directory %s is not empty", cleanPath(target))
+	}
+	s.tree.RUnlock()
+	if err != nil {
+		return err
+	}
+	apiCtx := s.api()
+	if err := apiCtx.DeleteEntry(node); err != nil {
+		return fmt.Errorf("failed to delete %s: %s: %w", cleanPath(target), err, ErrApi)
+	}
+	s.tree.Lock()
+	defer s.tree.Unlock()
+	apiCtx.Filetree.DeleteNode(node)
+	return nil
+}
+
+type bundleMetadata struct {
+	VisibleName  string `json:"visibleName"`
+	Parent       string `json:"parent"`
+	Type         string `json:"type"`
+	Version      int    `json:"version"`
+	LastModified string `json:"lastModified"`
+}
+
+// Get downloads the document at target and unpacks its bundle (content,
+// page files, metadata and highlights) into the dest directory.
+func (s *Connection) Get(target, dest string) error {
+	return s.GetContext(context.Background(), target, dest)
+}
 
-	_, err = s.apiCtx.Filetree.NodeByPath(docName, destNode)
+// GetContext is Get, aborting the download once ctx is done.
+func (s *Connection) GetContext(ctx context.Context, target, dest string) error {
+	s.tree.RLock()
+	node, err := s.node(target)
+	if err == nil && node.IsDirectory() {
+		err = fmt.Errorf("document %s: %w", cleanPath(target), ErrNotFound)
+	}
+	var meta bundleMetadata
 	if err == nil {
-		return fmt.Errorf("destination file %s: %w", docName, ErrAlreadyExists)
+		meta = bundleMetadata{
+			VisibleName:  node.Name(),
+			Parent:       node.Document.Parent,
+			Type:         node.Document.Type,
+			Version:      node.Version(),
+			LastModified: node.Document.ModifiedClient,
+		}
+	}
+	s.tree.RUnlock()
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile("", "bundle.*.zip")
+	if err != nil {
+		return fmt.Errorf("cannot create bundle temporary file: %w", err)
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+	if err := s.fetch(ctx, node.Id(), tmp.Name()); err != nil {
+		return fmt.Errorf("failed to download %s: %s: %w", cleanPath(target), err, ErrApi)
+	}
+	if err := unzip(tmp.Name(), dest); err != nil {
+		return fmt.Errorf("cannot unpack bundle of %s: %w", cleanPath(target), err)
+	}
+	// Cloud bundles don't carry the document metadata
+	metaPath := filepath.Join(dest, node.Id()+".metadata")
+	if _, err := os.Stat(metaPath); os.IsNotExist(err) {
+		content, err := json.MarshalIndent(meta, "", "    ")
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(metaPath, content, 0644); err != nil {
+			return fmt.Errorf("cannot write bundle metadata: %w", err)
+		}
 	}
+	return nil
+}
+
+// fetch mirrors rmapi's document download, saving the bundle of
+// document id into dst.
+func (s *Connection) fetch(ctx context.Context, id, dst string) error {
+	client := s.api().Http
+	documents := []rmModel.Document{}
+	target := docHost + listDocsPath + "?withBlob=true&doc=" + url.QueryEscape(id)
+	if err := send(ctx, client.Client, http.MethodGet, target, client.Tokens.UserToken, nil, &documents); err != nil {
+		return err
+	}
+	if len(documents) <= 0 || len(documents[0].BlobURLGet) <= 0 {
+		return errors.New("no download URL returned")
+	}
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if err := send(ctx, client.Client, http.MethodGet, documents[0].BlobURLGet, client.Tokens.UserToken, nil, f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
 
-	document, err := s.apiCtx.UploadDocument(destNode.Id(), srcName)
+func unzip(src, dest string) error {
+	r, err := zip.OpenReader(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return err
+	}
+	root, err := filepath.Abs(dest)
 	if err != nil {
-		return fmt.Errorf("failed to upload file %s: %s: %w", srcName, err, ErrApi)
+		return err
+	}
+	for _, f := range r.File {
+		target := filepath.Join(root, filepath.FromSlash(f.Name))
+		if !strings.HasPrefix(target, root+string(filepath.Separator)) {
+			return fmt.Errorf("illegal file path in bundle: %s", f.Name)
+		}
+		if f.FileInfo().IsDir() {
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		if err := extractFile(f, target); err != nil {
+			return err
+		}
 	}
-	s.apiCtx.Filetree.AddDocument(*document)
 	return nil
 }
+
+func extractFile(f *zip.File, target string) error {
+	in, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
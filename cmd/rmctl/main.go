@@ -0,0 +1,321 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/kennygrant/sanitize"
+	"github.com/nazavode/rm"
+	"github.com/nazavode/rm/credentials"
+	"github.com/nazavode/rm/lines"
+	log "github.com/sirupsen/logrus"
+	cli "github.com/urfave/cli/v2"
+)
+
+// connect opens a connection with the tokens given on the command
+// line or, failing that, the ones saved by rmd auth remarkable. User
+// tokens obtained along the way are saved back, to be reused.
+func connect(ctx *cli.Context) (*rm.Connection, error) {
+	p := ctx.String("credentials")
+	if len(p) <= 0 {
+		p = credentials.DefaultPath()
+	}
+	cr, err := credentials.Load(p)
+	if err != nil {
+		return nil, err
+	}
+	deviceToken, userToken := cr.RemarkableDeviceToken, cr.RemarkableUserToken
+	if d := ctx.String("rm-device"); len(d) > 0 && d != deviceToken {
+		// The saved user token belongs to another device
+		deviceToken, userToken = d, ""
+	}
+	if u := ctx.String("rm-user"); len(u) > 0 {
+		userToken = u
+	}
+	if len(deviceToken) <= 0 {
+		return nil, errors.New("no reMarkable device token available, use --rm-device or rmd auth remarkable")
+	}
+	save := func(userToken string) {
+		saved, err := credentials.SaveUserToken(p, deviceToken, userToken)
+		if err != nil {
+			log.WithError(err).Warn("failed to save reMarkable user token")
+		} else if saved {
+			log.WithField("path", p).Trace("reMarkable user token saved")
+		}
+	}
+	onRefresh := rm.OnRefresh(func(userToken string, err error) {
+		if err == nil {
+			save(userToken)
+		}
+	})
+	if len(userToken) > 0 {
+		conn, err := rm.NewConnection(deviceToken, userToken, onRefresh)
+		if err == nil {
+			return conn, nil
+		}
+		log.WithError(err).Trace("connection with provided user token failed")
+	}
+	log.Trace("requesting a new reMarkable user token")
+	userToken, err = rm.NewUserToken(deviceToken)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := rm.NewConnection(deviceToken, userToken, onRefresh)
+	if err != nil {
+		return nil, err
+	}
+	save(userToken)
+	return conn, nil
+}
+
+func printEntry(e *rm.Entry) {
+	kind := "f"
+	if e.IsDir {
+		kind = "d"
+	}
+	fmt.Printf("%s\t%s\t%s\t%s\n", kind, e.ID, e.Modified.Format(time.RFC3339), e.Path)
+}
+
+func expectArgs(ctx *cli.Context, n int) error {
+	if ctx.NArg() != n {
+		return fmt.Errorf("%s: expected %d arguments, got %d", ctx.Command.Name, n, ctx.NArg())
+	}
+	return nil
+}
+
+func cmdList(ctx *cli.Context) error {
+	dir := "/"
+	if ctx.NArg() > 0 {
+		dir = ctx.Args().First()
+	}
+	conn, err := connect(ctx)
+	if err != nil {
+		return err
+	}
+	entries, err := conn.List(dir)
+	if err != nil {
+		return err
+	}
+	for i := range entries {
+		printEntry(&entries[i])
+	}
+	return nil
+}
+
+func cmdStat(ctx *cli.Context) error {
+	if err := expectArgs(ctx, 1); err != nil {
+		return err
+	}
+	conn, err := connect(ctx)
+	if err != nil {
+		return err
+	}
+	entry, err := conn.Stat(ctx.Args().First())
+	if err != nil {
+		return err
+	}
+	printEntry(entry)
+	return nil
+}
+
+func cmdMkDir(ctx *cli.Context) error {
+	if ctx.NArg() < 1 {
+		return fmt.Errorf("%s: expected at least 1 argument", ctx.Command.Name)
+	}
+	conn, err := connect(ctx)
+	if err != nil {
+		return err
+	}
+	for _, target := range ctx.Args().Slice() {
+		if err := conn.MkDir(target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func cmdMove(ctx *cli.Context) error {
+	if err := expectArgs(ctx, 2); err != nil {
+		return err
+	}
+	conn, err := connect(ctx)
+	if err != nil {
+		return err
+	}
+	return conn.Move(ctx.Args().Get(0), ctx.Args().Get(1))
+}
+
+func cmdRename(ctx *cli.Context) error {
+	if err := expectArgs(ctx, 2); err != nil {
+		return err
+	}
+	conn, err := connect(ctx)
+	if err != nil {
+		return err
+	}
+	return conn.Rename(ctx.Args().Get(0), ctx.Args().Get(1))
+}
+
+func cmdDelete(ctx *cli.Context) error {
+	if ctx.NArg() < 1 {
+		return fmt.Errorf("%s: expected at least 1 argument", ctx.Command.Name)
+	}
+	conn, err := connect(ctx)
+	if err != nil {
+		return err
+	}
+	for _, target := range ctx.Args().Slice() {
+		if err := conn.Delete(target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func cmdGet(ctx *cli.Context) error {
+	if ctx.NArg() < 1 || ctx.NArg() > 2 {
+		return fmt.Errorf("%s: expected 1 or 2 arguments, got %d", ctx.Command.Name, ctx.NArg())
+	}
+	conn, err := connect(ctx)
+	if err != nil {
+		return err
+	}
+	target := ctx.Args().Get(0)
+	dest := ctx.Args().Get(1)
+	if len(dest) <= 0 {
+		entry, err := conn.Stat(target)
+		if err != nil {
+			return err
+		}
+		dest = sanitize.Name(entry.Name)
+	}
+	return conn.Get(target, dest)
+}
+
+func cmdExport(ctx *cli.Context) error {
+	if err := expectArgs(ctx, 2); err != nil {
+		return err
+	}
+	bundle, err := lines.OpenBundle(ctx.Args().Get(0))
+	if err != nil {
+		return err
+	}
+	if ctx.Bool("svg") {
+		return bundle.ExportSVG(ctx.Args().Get(1))
+	}
+	err = bundle.ExportPDF(ctx.Args().Get(1), ctx.Duration("timeout"))
+	if errors.Is(err, lines.ErrNoOverlay) {
+		log.WithError(err).Warn("PDF exported without the original pages")
+		return nil
+	}
+	return err
+}
+
+func main() {
+	app := &cli.App{
+		Name:     "rmctl",
+		Usage:    "Manage documents on reMarkable cloud (https://my.remarkable.com)",
+		Version:  "v0.1a",
+		Compiled: time.Now(),
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "credentials",
+				Usage:   "Load reMarkable tokens saved by rmd auth from YAML `FILE`, saving renewed ones there (default: rmd/credentials.yaml in the user configuration directory)",
+				EnvVars: []string{"RMD_CREDENTIALS"},
+			},
+			&cli.StringFlag{
+				Name:    "rm-device",
+				Usage:   "Use `STRING` as reMarkable cloud API device token",
+				EnvVars: []string{"RMD_RM_DEVICE_TOKEN"},
+			},
+			&cli.StringFlag{
+				Name:    "rm-user",
+				Usage:   "Use `STRING` as reMarkable cloud API user token; if not provided will be generated",
+				EnvVars: []string{"RMD_RM_USER_TOKEN"},
+			},
+			&cli.BoolFlag{
+				Name:    "verbose",
+				Aliases: []string{"v"},
+				Usage:   "Verbose mode. Causes rmctl to print debugging messages about its progress.",
+				EnvVars: []string{"RMD_VERBOSE"},
+			},
+		},
+		Before: func(ctx *cli.Context) error {
+			log.SetLevel(log.WarnLevel)
+			if ctx.Bool("verbose") {
+				log.SetLevel(log.TraceLevel)
+			}
+			return nil
+		},
+		Commands: []*cli.Command{
+			{
+				Name:      "ls",
+				Usage:     "List the content of a directory",
+				ArgsUsage: "[DIR]",
+				Action:    cmdList,
+			},
+			{
+				Name:      "stat",
+				Usage:     "Show a document or directory",
+				ArgsUsage: "PATH",
+				Action:    cmdStat,
+			},
+			{
+				Name:      "mkdir",
+				Usage:     "Create directories along with any missing parent",
+				ArgsUsage: "DIR...",
+				Action:    cmdMkDir,
+			},
+			{
+				Name:      "mv",
+				Usage:     "Move a document or directory into another directory",
+				ArgsUsage: "SRC DESTDIR",
+				Action:    cmdMove,
+			},
+			{
+				Name:      "rename",
+				Usage:     "Rename a document or directory",
+				ArgsUsage: "PATH NAME",
+				Action:    cmdRename,
+			},
+			{
+				Name:      "get",
+				Usage:     "Download a document bundle, annotations included, into a local directory",
+				ArgsUsage: "PATH [DESTDIR]",
+				Action:    cmdGet,
+			},
+			{
+				Name:      "export",
+				Usage:     "Render the annotations of a downloaded document bundle as an annotated PDF",
+				ArgsUsage: "BUNDLEDIR OUTPUT",
+				Action:    cmdExport,
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "svg",
+						Usage: "Write one SVG file per annotated page into the OUTPUT directory instead",
+					},
+					&cli.DurationFlag{
+						Name:  "timeout",
+						Usage: "Use `DURATION` as the hard timeout for external programs",
+						Value: 30 * time.Second,
+					},
+				},
+			},
+			{
+				Name:      "rm",
+				Usage:     "Delete documents or empty directories",
+				ArgsUsage: "PATH...",
+				Action:    cmdDelete,
+			},
+		},
+	}
+	cli.VersionFlag = &cli.BoolFlag{
+		Name:  "version",
+		Usage: "print the version and exit",
+	}
+	if err := app.Run(os.Args); err != nil {
+		log.Fatal(err)
+	}
+}
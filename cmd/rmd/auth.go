@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/nazavode/rm"
+	"github.com/nazavode/rm/credentials"
+	"github.com/nazavode/rm/pocket"
+	log "github.com/sirupsen/logrus"
+	cli "github.com/urfave/cli/v2"
+)
+
+// applyCredentials sets the fields of c found in cr.
+func applyCredentials(cr *credentials.Credentials, c *conf) {
+	for _, field := range []struct{ dst, src *string }{
+		{&c.RemarkableDeviceToken, &cr.RemarkableDeviceToken},
+		{&c.RemarkableUserToken, &cr.RemarkableUserToken},
+		{&c.PocketKey, &cr.PocketKey},
+		{&c.PocketToken, &cr.PocketToken},
+	} {
+		if len(*field.src) > 0 {
+			*field.dst = *field.src
+		}
+	}
+}
+
+// credentialsPath returns the credentials file location, by default
+// rmd/credentials.yaml in the user configuration directory.
+func credentialsPath(ctx *cli.Context) string {
+	if p := ctx.String("credentials"); len(p) > 0 {
+		return p
+	}
+	return credentials.DefaultPath()
+}
+
+// authPocket obtains a Pocket access token through the OAuth flow,
+// the user granting access from the browser.
+func authPocket(ctx *cli.Context, c *conf) error {
+	if len(c.PocketKey) <= 0 {
+		return errors.New("a Pocket consumer key is required (--pocket-key), create one at https://getpocket.com/developer/apps/new")
+	}
+	prompt := func(authURL string) {
+		fmt.Printf("Visit the following URL to grant rmd access to your Pocket account:\n\n  %s\n\n", authURL)
+	}
+	auth, user, err := pocket.AuthenticateContext(ctx.Context, c.PocketKey, ctx.String("listen"), prompt, ctx.Duration("wait"))
+	if err != nil {
+		return err
+	}
+	p := credentialsPath(ctx)
+	err = credentials.Save(p, func(cr *credentials.Credentials) {
+		cr.PocketKey = auth.ConsumerKey
+		cr.PocketToken = auth.AccessToken
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Access granted by Pocket user %s, credentials saved to %s\n", user, p)
+	return nil
+}
+
+// saveUserToken persists a user token renewed for the device
+// registered by rmd auth remarkable; tokens for devices configured
+// elsewhere are left alone.
+func saveUserToken(c *conf, userToken string) {
+	saved, err := credentials.SaveUserToken(c.credentials, c.RemarkableDeviceToken, userToken)
+	if err != nil {
+		log.WithError(err).Warn("failed to save reMarkable user token")
+	} else if saved {
+		log.WithField("path", c.credentials).Trace("reMarkable user token saved")
+	}
+}
+
+// authRemarkable registers rmd as a new device on the reMarkable
+// cloud, the one-time code being either the first argument or read
+// from standard input.
+func authRemarkable(ctx *cli.Context, c *conf) error {
+	code := ctx.Args().First()
+	if len(code) <= 0 {
+		fmt.Print("Get a one-time code from https://my.remarkable.com/device/desktop/connect and enter it: ")
+		line, err := readLine(ctx.Context)
+		if err != nil {
+			return fmt.Errorf("cannot read one-time code: %w", err)
+		}
+		code = strings.TrimSpace(line)
+	}
+	deviceToken, err := rm.RegisterDeviceContext(ctx.Context, code)
+	if err != nil {
+		return err
+	}
+	userToken, err := rm.NewUserTokenContext(ctx.Context, deviceToken)
+	if err != nil {
+		return err
+	}
+	p := credentialsPath(ctx)
+	err = credentials.Save(p, func(cr *credentials.Credentials) {
+		cr.RemarkableDeviceToken = deviceToken
+		cr.RemarkableUserToken = userToken
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Device registered, credentials saved to %s\n", p)
+	return nil
+}
+
+// readLine reads a line from standard input, giving up once ctx is
+// done.
+func readLine(ctx context.Context) (string, error) {
+	type result struct {
+		line string
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if len(line) > 0 {
+			err = nil
+		}
+		done <- result{line, err}
+	}()
+	select {
+	case r := <-done:
+		return r.line, r.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/nazavode/rm"
+	"github.com/nazavode/rm/credentials"
+	cli "github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v2"
+)
+
+const redacted = "<redacted>"
+
+// newConf builds the effective configuration: command line flags
+// take precedence over environment variables, which take precedence
+// over the configuration file, then the credentials file and
+// finally defaults.
+func newConf(ctx *cli.Context) (*conf, error) {
+	c := &conf{}
+	if err := bind(ctx, c, false); err != nil {
+		return nil, err
+	}
+	c.credentials = credentialsPath(ctx)
+	cr, err := credentials.Load(c.credentials)
+	if err != nil {
+		return nil, err
+	}
+	applyCredentials(cr, c)
+	if name := ctx.String("config"); len(name) > 0 {
+		content, err := ioutil.ReadFile(name)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read configuration file: %w", err)
+		}
+		if err := yaml.UnmarshalStrict(content, c); err != nil {
+			return nil, fmt.Errorf("invalid configuration file %s: %w", name, err)
+		}
+	}
+	if err := bind(ctx, c, true); err != nil {
+		return nil, err
+	}
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// bind copies flag values into c; when onlySet is true, only flags
+// explicitly set on the command line or via environment are copied.
+func bind(ctx *cli.Context, c *conf, onlySet bool) error {
+	set := func(name string) bool {
+		if !onlySet {
+			return true
+		}
+		// Subcommand contexts don't see environment set global flags
+		for _, c := range ctx.Lineage() {
+			if c.IsSet(name) {
+				return true
+			}
+		}
+		return false
+	}
+	if set("retry") {
+		c.ConnectionAttempts = ctx.Int("retry")
+	}
+	if set("keep") {
+		c.Keep = ctx.Bool("keep")
+	}
+	if set("timeout") {
+		c.Timeout = ctx.Duration("timeout")
+	}
+	if set("interval") {
+		c.PollInterval = ctx.Duration("interval")
+	}
+	if set("workers") {
+		c.Workers = ctx.Int("workers")
+	}
+	if set("fetch-workers") {
+		c.FetchWorkers = ctx.Int("fetch-workers")
+	}
+	if set("convert-workers") {
+		c.ConvertWorkers = ctx.Int("convert-workers")
+	}
+	if set("host-workers") {
+		c.HostWorkers = ctx.Int("host-workers")
+	}
+	if set("queue") {
+		c.QueueSize = ctx.Int("queue")
+	}
+	if set("format") {
+		c.Format = ctx.String("format")
+	}
+	if set("converter") {
+		c.Converter = ctx.String("converter")
+	}
+	if set("images") {
+		c.Images = ctx.Bool("images")
+	}
+	if set("max-images") {
+		c.MaxImages = ctx.Int("max-images")
+	}
+	if set("max-image-size") {
+		c.MaxImageSize = ctx.Int64("max-image-size")
+	}
+	if set("cover") {
+		c.Cover = ctx.Bool("cover")
+	}
+	if set("state-dir") {
+		c.StateDir = ctx.String("state-dir")
+	}
+	if set("dest") {
+		c.DestDir = ctx.String("dest")
+	}
+	if set("rm-device") {
+		c.RemarkableDeviceToken = ctx.String("rm-device")
+	}
+	if set("rm-user") {
+		c.RemarkableUserToken = ctx.String("rm-user")
+	}
+	if set("pocket-key") {
+		c.PocketKey = ctx.String("pocket-key")
+	}
+	if set("pocket-token") {
+		c.PocketToken = ctx.String("pocket-token")
+	}
+	if set("pocket-archive") {
+		c.PocketArchive = ctx.Bool("pocket-archive")
+	}
+	if set("pocket-retag") {
+		c.PocketRetag = ctx.String("pocket-retag")
+	}
+	if set("name") {
+		c.NameTemplate = ctx.String("name")
+	}
+	if set("on-conflict") {
+		c.Conflict = ctx.String("on-conflict")
+	}
+	if set("feed") {
+		c.Feeds = ctx.StringSlice("feed")
+	}
+	if set("feed-content") {
+		c.FeedContent = ctx.Bool("feed-content")
+	}
+	if set("watch") {
+		c.Watch = ctx.StringSlice("watch")
+	}
+	if set("wallabag-url") {
+		c.WallabagURL = ctx.String("wallabag-url")
+	}
+	if set("wallabag-client-id") {
+		c.WallabagClientID = ctx.String("wallabag-client-id")
+	}
+	if set("wallabag-client-secret") {
+		c.WallabagClientSecret = ctx.String("wallabag-client-secret")
+	}
+	if set("wallabag-user") {
+		c.WallabagUser = ctx.String("wallabag-user")
+	}
+	if set("wallabag-password") {
+		c.WallabagPassword = ctx.String("wallabag-password")
+	}
+	if set("wallabag-tag") {
+		c.WallabagTag = ctx.String("wallabag-tag")
+	}
+	if set("wallabag-content") {
+		c.WallabagContent = ctx.Bool("wallabag-content")
+	}
+	if set("wallabag-archive") {
+		c.WallabagArchive = ctx.Bool("wallabag-archive")
+	}
+	if set("wallabag-retag") {
+		c.WallabagRetag = ctx.String("wallabag-retag")
+	}
+	if set("route") {
+		c.Routes = nil
+		for _, s := range ctx.StringSlice("route") {
+			r, err := parseRoute(s)
+			if err != nil {
+				return err
+			}
+			c.Routes = append(c.Routes, r)
+		}
+	}
+	return nil
+}
+
+func (c *conf) validate() error {
+	errs := []string{}
+	invalid := func(key, format string, args ...interface{}) {
+		errs = append(errs, fmt.Sprintf("%s: %s", key, fmt.Sprintf(format, args...)))
+	}
+	if c.ConnectionAttempts < 0 {
+		invalid("retry", "must not be negative, got %d", c.ConnectionAttempts)
+	}
+	if c.Timeout <= 0 {
+		invalid("timeout", "must be positive, got %s", c.Timeout)
+	}
+	if c.PollInterval <= 0 {
+		invalid("interval", "must be positive, got %s", c.PollInterval)
+	}
+	if c.Workers <= 0 {
+		invalid("workers", "must be positive, got %d", c.Workers)
+	}
+	for _, field := range []struct {
+		key   string
+		value int
+	}{
+		{"fetch_workers", c.FetchWorkers},
+		{"convert_workers", c.ConvertWorkers},
+		{"host_workers", c.HostWorkers},
+		{"queue", c.QueueSize},
+	} {
+		if field.value < 0 {
+			invalid(field.key, "must not be negative, got %d", field.value)
+		}
+	}
+	if _, err := selectConverter(c); err != nil {
+		invalid("format", "%s", err)
+	}
+	if _, err := rm.ParseConflictPolicy(c.Conflict); err != nil {
+		invalid("on_conflict", "%s", err)
+	}
+	if namer, err := rm.NewNamer(c.NameTemplate); err != nil {
+		invalid("name", "%s", err)
+	} else {
+		c.namer = namer
+	}
+	if c.MaxImages < 0 {
+		invalid("max_images", "must not be negative, got %d", c.MaxImages)
+	}
+	if c.MaxImageSize <= 0 {
+		invalid("max_image_size", "must be positive, got %d", c.MaxImageSize)
+	}
+	if !strings.HasPrefix(c.DestDir, "/") {
+		invalid("dest", "must be an absolute path, got %q", c.DestDir)
+	}
+	for i, r := range c.Routes {
+		if err := r.validate(); err != nil {
+			invalid(fmt.Sprintf("routes[%d]", i), "%s", err)
+		}
+	}
+	for i, f := range c.Feeds {
+		if u, err := url.Parse(f); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			invalid(fmt.Sprintf("feeds[%d]", i), "not a HTTP URL: %q", f)
+		}
+	}
+	for i, dir := range c.Watch {
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			invalid(fmt.Sprintf("watch[%d]", i), "not a directory: %q", dir)
+		}
+	}
+	if len(c.WallabagURL) > 0 {
+		if u, err := url.Parse(c.WallabagURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			invalid("wallabag_url", "not a HTTP URL: %q", c.WallabagURL)
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration:\n  %s", strings.Join(errs, "\n  "))
+	}
+	return nil
+}
+
+// redact returns a copy of c with secrets hidden.
+func (c *conf) redact() *conf {
+	r := *c
+	for _, secret := range []*string{&r.RemarkableDeviceToken, &r.RemarkableUserToken, &r.PocketKey, &r.PocketToken, &r.WallabagClientSecret, &r.WallabagPassword} {
+		if len(*secret) > 0 {
+			*secret = redacted
+		}
+	}
+	return &r
+}
+
+// printConf writes c as YAML, durations in human readable form.
+func printConf(w io.Writer, c *conf) error {
+	content, err := yaml.Marshal(c)
+	if err != nil {
+		return err
+	}
+	fields := yaml.MapSlice{}
+	if err := yaml.Unmarshal(content, &fields); err != nil {
+		return err
+	}
+	for i := range fields {
+		switch fields[i].Key {
+		case "timeout":
+			fields[i].Value = c.Timeout.String()
+		case "interval":
+			fields[i].Value = c.PollInterval.String()
+		}
+	}
+	if content, err = yaml.Marshal(fields); err != nil {
+		return err
+	}
+	_, err = w.Write(content)
+	return err
+}
+
+func configCheck(ctx *cli.Context) error {
+	c, err := newConf(ctx)
+	if err != nil {
+		return err
+	}
+	missing := []string{}
+	if len(c.RemarkableDeviceToken) <= 0 {
+		missing = append(missing, "rm_device")
+	}
+	if len(c.PocketKey) > 0 && len(c.PocketToken) <= 0 {
+		missing = append(missing, "pocket_token")
+	}
+	if len(c.PocketToken) > 0 && len(c.PocketKey) <= 0 {
+		missing = append(missing, "pocket_key")
+	}
+	if len(c.WallabagURL) > 0 {
+		for _, field := range []struct{ key, value string }{
+			{"wallabag_client_id", c.WallabagClientID},
+			{"wallabag_client_secret", c.WallabagClientSecret},
+			{"wallabag_user", c.WallabagUser},
+			{"wallabag_password", c.WallabagPassword},
+		} {
+			if len(field.value) <= 0 {
+				missing = append(missing, field.key)
+			}
+		}
+	}
+	if err := printConf(os.Stdout, c.redact()); err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		return errors.New("missing credentials: " + strings.Join(missing, ", ") + "; see rmd auth")
+	}
+	if len(newSources(c)) <= 0 {
+		return errors.New("no sources configured")
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+
+	"github.com/kennygrant/sanitize"
+	"github.com/nazavode/rm"
+	"github.com/nazavode/rm/lines"
+	"github.com/nazavode/rm/pocket"
+	"github.com/nazavode/rm/state"
+	log "github.com/sirupsen/logrus"
+)
+
+func fetchHighlights(ctx context.Context, c *conf, conn *rm.Connection, documentID string) (string, []lines.Highlight, error) {
+	entry, err := conn.StatID(documentID)
+	if err != nil {
+		return "", nil, err
+	}
+	dir, err := ioutil.TempDir(c.WorkDir, "bundle")
+	if err != nil {
+		return "", nil, err
+	}
+	if !c.Keep {
+		defer os.RemoveAll(dir)
+	}
+	if err := conn.GetContext(ctx, entry.Path, dir); err != nil {
+		return "", nil, err
+	}
+	bundle, err := lines.OpenBundle(dir)
+	if err != nil {
+		return "", nil, err
+	}
+	highlights, err := bundle.Highlights()
+	if err != nil {
+		return "", nil, err
+	}
+	title := bundle.Name
+	if len(title) <= 0 {
+		title = entry.Name
+	}
+	return title, highlights, nil
+}
+
+func writeNote(dir, name, title, source string, highlights []lines.Highlight) error {
+	f, err := os.Create(path.Join(dir, sanitize.Name(name)+".md"))
+	if err != nil {
+		return err
+	}
+	if err := lines.WriteMarkdown(f, title, source, highlights); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// highlightsMain collects the highlights of every document uploaded
+// by rmd, writing a Markdown note per document into notesDir and
+// tagging the originating Pocket items with tag. The Pocket API
+// doesn't expose annotations, so tagging is the only feedback
+// that can be pushed upstream.
+func highlightsMain(ctx context.Context, c *conf, notesDir, tag string) error {
+	if len(c.StateDir) <= 0 {
+		return errors.New("highlights export requires a persistent sync state (--state-dir)")
+	}
+	if len(notesDir) <= 0 && len(tag) <= 0 {
+		return errors.New("nothing to do: neither notes directory nor Pocket tag provided")
+	}
+	store, err := state.Open(c.StateDir)
+	if err != nil {
+		return err
+	}
+	if len(notesDir) > 0 {
+		if err := os.MkdirAll(notesDir, 0755); err != nil {
+			return fmt.Errorf("cannot create notes directory %s: %w", notesDir, err)
+		}
+	}
+	log.Trace("connecting to reMarkable cloud")
+	conn, err := rmConnect(ctx, c)
+	if err != nil {
+		return err
+	}
+	actions := []pocket.Action{}
+	for _, item := range store.Items() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if item.Status != state.Uploaded || len(item.DocumentID) <= 0 {
+			continue
+		}
+		log := log.WithFields(log.Fields{"item": item.ID, "document": item.DocumentID})
+		title, highlights, err := fetchHighlights(ctx, c, conn, item.DocumentID)
+		if errors.Is(err, rm.ErrNotFound) {
+			log.Trace("document no longer available, skipping")
+			continue
+		} else if err != nil {
+			log.WithError(err).Warn("failed to retrieve highlights, skipping")
+			continue
+		}
+		if len(highlights) <= 0 {
+			log.Trace("no highlights found")
+			continue
+		}
+		log.WithField("count", len(highlights)).Trace("highlights found")
+		if len(notesDir) > 0 {
+			name := item.Slug
+			if len(name) <= 0 {
+				name = item.DocumentID
+			}
+			if err := writeNote(notesDir, name, title, item.URL, highlights); err != nil {
+				log.WithError(err).Warn("failed to write note")
+			}
+		}
+		if len(tag) > 0 && item.Source == pocket.SourceName {
+			if pocketID, err := strconv.Atoi(item.ID); err == nil {
+				actions = append(actions, pocket.TagsAdd(pocketID, tag))
+			}
+		}
+	}
+	if len(actions) <= 0 {
+		return nil
+	}
+	pocketConn := &pocket.Auth{
+		ConsumerKey: c.PocketKey,
+		AccessToken: c.PocketToken,
+	}
+	log.WithField("count", len(actions)).Trace("tagging highlighted items on Pocket")
+	return pocketConn.ModifyContext(ctx, actions...)
+}
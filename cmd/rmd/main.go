@@ -1,68 +1,188 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/url"
 	"os"
 	"os/exec"
 	"os/signal"
 	"path"
+	"path/filepath"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/nazavode/rm"
+	"github.com/nazavode/rm/feed"
+	"github.com/nazavode/rm/folder"
 	"github.com/nazavode/rm/pocket"
+	"github.com/nazavode/rm/state"
+	"github.com/nazavode/rm/wallabag"
 	log "github.com/sirupsen/logrus"
 	cli "github.com/urfave/cli/v2"
 )
 
 type conf struct {
-	ConnectionAttempts    int
-	Keep                  bool
-	Timeout               time.Duration
-	PollInterval          time.Duration
-	WorkDir               string
-	DestDir               string
-	RemarkableDeviceToken string
-	RemarkableUserToken   string
-	PocketKey             string
-	PocketToken           string
+	ConnectionAttempts    int           `yaml:"retry"`
+	Keep                  bool          `yaml:"keep"`
+	Timeout               time.Duration `yaml:"timeout"`
+	PollInterval          time.Duration `yaml:"interval"`
+	Workers               int           `yaml:"workers"`
+	FetchWorkers          int           `yaml:"fetch_workers"`
+	ConvertWorkers        int           `yaml:"convert_workers"`
+	HostWorkers           int           `yaml:"host_workers"`
+	QueueSize             int           `yaml:"queue"`
+	Format                string        `yaml:"format"`
+	Converter             string        `yaml:"converter"`
+	Images                bool          `yaml:"images"`
+	MaxImages             int           `yaml:"max_images"`
+	MaxImageSize          int64         `yaml:"max_image_size"`
+	Cover                 bool          `yaml:"cover"`
+	WorkDir               string        `yaml:"-"`
+	StateDir              string        `yaml:"state_dir"`
+	DestDir               string        `yaml:"dest"`
+	RemarkableDeviceToken string        `yaml:"rm_device"`
+	RemarkableUserToken   string        `yaml:"rm_user"`
+	PocketKey             string        `yaml:"pocket_key"`
+	PocketToken           string        `yaml:"pocket_token"`
+	PocketArchive         bool          `yaml:"pocket_archive"`
+	PocketRetag           string        `yaml:"pocket_retag"`
+	Conflict              string        `yaml:"on_conflict"`
+	NameTemplate          string        `yaml:"name"`
+	Routes                []route       `yaml:"routes,omitempty"`
+	Feeds                 []string      `yaml:"feeds,omitempty"`
+	FeedContent           bool          `yaml:"feed_content"`
+	Watch                 []string      `yaml:"watch,omitempty"`
+	WallabagURL           string        `yaml:"wallabag_url"`
+	WallabagClientID      string        `yaml:"wallabag_client_id"`
+	WallabagClientSecret  string        `yaml:"wallabag_client_secret"`
+	WallabagUser          string        `yaml:"wallabag_user"`
+	WallabagPassword      string        `yaml:"wallabag_password"`
+	WallabagTag           string        `yaml:"wallabag_tag"`
+	WallabagContent       bool          `yaml:"wallabag_content"`
+	WallabagArchive       bool          `yaml:"wallabag_archive"`
+	WallabagRetag         string        `yaml:"wallabag_retag"`
+	namer                 *rm.Namer
+	credentials           string
+}
+
+const pocketTag = "rm"
+
+type converter func(context.Context, rm.Document, string, time.Duration) error
+
+var converters = map[string]converter{
+	"native": rm.DocumentToEPUBNativeContext,
+	"pandoc": rm.DocumentToEPUBContext,
+}
+
+func documentToPDF(ctx context.Context, d rm.Document, filename string, timeout time.Duration) error {
+	return rm.DocumentToPDFContext(ctx, d, filename, timeout)
+}
+
+func selectConverter(c *conf) (converter, error) {
+	switch c.Format {
+	case "epub":
+		if conv, ok := converters[c.Converter]; ok {
+			return conv, nil
+		}
+		return nil, fmt.Errorf("unknown converter: %s", c.Converter)
+	case "pdf":
+		return documentToPDF, nil
+	}
+	return nil, fmt.Errorf("unknown output format: %s", c.Format)
+}
+
+func usesPandoc(c *conf) bool {
+	return c.Format == "pdf" || c.Converter == "pandoc"
 }
 
 type document struct {
 	ID       uint64
+	Source   string
+	Item     *rm.Item
 	FilePath string
+	Name     string
+	DestDir  string
 }
 
-func doPut(c *conf, conn *rm.Connection, doc *document) (*rm.Connection, error) {
-	log := log.WithFields(log.Fields{"id": doc.ID, "path": doc.FilePath})
-	err := conn.Put(doc.FilePath, c.DestDir)
-	if errors.Is(err, rm.ErrAlreadyExists) {
-		log.Trace("file already exists")
-		return conn, nil
-	} else if errors.Is(err, rm.ErrApi) {
+// conflictPolicy returns the configured conflict policy, unless the
+// document clashes with one uploaded from a different source, which
+// is never skipped nor overwritten.
+func conflictPolicy(c *conf, conn *rm.Connection, store *state.Store, doc *document) rm.ConflictPolicy {
+	policy, _ := rm.ParseConflictPolicy(c.Conflict)
+	existing, err := conn.Stat(path.Join(doc.DestDir, doc.Name))
+	if err != nil {
+		return policy
+	}
+	return resolveConflict(policy, store, doc, existing.ID)
+}
+
+// resolveConflict returns policy if the existing document is known
+// to come from the same item or URL as doc, rm.Rename otherwise.
+func resolveConflict(policy rm.ConflictPolicy, store *state.Store, doc *document, existingID string) rm.ConflictPolicy {
+	fields := log.Fields{"id": doc.ID, "document": existingID}
+	owner, ok := store.ByDocumentID(existingID)
+	if !ok {
+		// Not uploaded by us, or before the sync state was kept:
+		// its source is unknown, so never take it for this one
+		log.WithFields(fields).Warn("document name already used by an untracked document, renaming")
+		return rm.Rename
+	}
+	sameItem := owner.Source == doc.Source && owner.ID == doc.Item.ID
+	if !sameItem && owner.URL != doc.Item.URL.String() {
+		log.WithFields(fields).WithField("url", owner.URL).
+			Warn("document name already used by a different article, renaming")
+		return rm.Rename
+	}
+	return policy
+}
+
+func doPut(ctx context.Context, c *conf, conn *rm.Connection, store *state.Store, doc *document) (*rm.Connection, string, error) {
+	policy := conflictPolicy(c, conn, store, doc)
+	put := func(conn *rm.Connection) (*rm.Entry, error) {
+		return conn.PutContext(ctx, doc.FilePath, doc.DestDir, rm.OnConflict(policy), rm.WithName(doc.Name))
+	}
+	reconnect := func() (*rm.Connection, error) {
+		return rmConnect(ctx, c)
+	}
+	return putRetrying(ctx, log.WithFields(log.Fields{"id": doc.ID, "path": doc.FilePath}), conn, put, reconnect)
+}
+
+// putRetrying calls put, once more on a new connection if the first
+// attempt fails on the cloud side.
+func putRetrying(ctx context.Context, log *log.Entry, conn *rm.Connection,
+	put func(*rm.Connection) (*rm.Entry, error), reconnect func() (*rm.Connection, error)) (*rm.Connection, string, error) {
+	entry, err := put(conn)
+	if errors.Is(err, rm.ErrApi) && ctx.Err() == nil {
 		log.WithError(err).Trace("document upload failed")
 		log.Trace("retrying upload by refreshing connection tokens")
-		newConn, err := rmConnect(c)
+		var newConn *rm.Connection
+		newConn, err = reconnect()
 		if err != nil {
-			return conn, err
+			return conn, "", err
 		}
 		conn = newConn
 		log.Trace("connection tokens refreshed")
-		err = conn.Put(doc.FilePath, c.DestDir)
-		if errors.Is(err, rm.ErrAlreadyExists) {
-			log.Trace("file already exists")
-			return conn, nil
-		}
+		entry, err = put(conn)
+	}
+	if errors.Is(err, rm.ErrAlreadyExists) {
+		log.Trace("file already exists, skipping")
+		return conn, entry.ID, nil
+	} else if err != nil {
+		return conn, "", err
 	}
-	return conn, err
+	log.WithField("document", entry.ID).WithField("version", entry.Version).Trace("document uploaded")
+	return conn, entry.ID, nil
 }
 
-func doUpload(c *conf, conn *rm.Connection, wg *sync.WaitGroup) (chan<- *document, chan<- bool) {
+func doUpload(ctx context.Context, c *conf, conn *rm.Connection, store *state.Store, wg *sync.WaitGroup) chan<- *document {
 	in := make(chan *document, 10)
-	stop := make(chan bool, 1)
 	go func() {
 		log.Trace("uploader started")
 		defer func() {
@@ -74,11 +194,24 @@ func doUpload(c *conf, conn *rm.Connection, wg *sync.WaitGroup) (chan<- *documen
 			select {
 			case doc := <-in:
 				dlog := log.WithFields(log.Fields{"id": doc.ID, "path": doc.FilePath})
-				conn, err = doPut(c, conn, doc)
+				var docID string
+				conn, docID, err = doPut(ctx, c, conn, store, doc)
 				if err != nil {
 					dlog.WithError(err).Warn("document failed")
+					markFailed(ctx, store, doc.Source, doc.Item, err)
 				} else {
-					dlog.Trace("done processing document")
+					if err := store.Update(doc.Source, doc.Item.ID, func(i *state.Item) {
+						i.DocumentID = docID
+						i.Status = state.Uploaded
+						i.Error = ""
+					}); err != nil {
+						dlog.WithError(err).Warn("failed to update sync state")
+					}
+					if err := doc.Item.AcknowledgeContext(ctx, nil); err != nil {
+						dlog.WithError(err).Warn("failed to acknowledge item")
+					} else {
+						dlog.Trace("done processing document")
+					}
 				}
 				if !c.Keep {
 					if err := os.Remove(doc.FilePath); err != nil {
@@ -88,65 +221,333 @@ func doUpload(c *conf, conn *rm.Connection, wg *sync.WaitGroup) (chan<- *documen
 						dlog.Trace("document removed")
 					}
 				}
-			case <-stop:
+			case <-ctx.Done():
 				log.Trace("uploader received shutdown request")
 				return
 			}
 		}
 	}()
-	return in, stop
+	return in
 }
 
-func doRetrieve(id uint64, c *conf, item *url.URL, upload chan<- *document, wg *sync.WaitGroup) {
-	defer wg.Done()
-	out := log.WithField("id", id)
+// maxAttempts is how many times failed items are retried, across
+// restarts, before giving up on them.
+const maxAttempts = 3
+
+// retryable tells whether i can be processed, either for the first
+// time or again after failing.
+func retryable(i state.Item) bool {
+	return i.Status == state.Pending || (i.Status == state.Failed && i.Attempts < maxAttempts)
+}
+
+// markFailed records the failure of an item, unless it's due to
+// shutdown: such items are left pending, to be resumed on restart.
+func markFailed(ctx context.Context, store *state.Store, source string, item *rm.Item, cause error) {
+	if ctx.Err() != nil {
+		log.WithFields(log.Fields{"source": source, "item": item.ID}).
+			Trace("processing aborted by shutdown, item left pending")
+		return
+	}
+	err := store.Update(source, item.ID, func(i *state.Item) {
+		i.Status = state.Failed
+		i.Error = cause.Error()
+		i.Attempts++
+	})
+	if err != nil {
+		log.WithError(err).Warn("failed to update sync state")
+	}
+	if err := item.AcknowledgeContext(ctx, cause); err != nil {
+		log.WithError(err).Warn("failed to acknowledge item")
+	}
+}
+
+func doRetrieve(ctx context.Context, id uint64, c *conf, lim *limits, source string, item *rm.Item, r route, store *state.Store, upload chan<- *document) {
+	out := log.WithFields(log.Fields{"id": id, "source": source, "item": item.ID})
 	out.Trace("worker started")
 	defer out.Trace("worker done")
-	// Download URL
-	out.WithField("url", item).Trace("retrieving item")
-	doc, err := rm.Retrieve(item, c.Timeout)
-	if err != nil {
-		out.WithField("url", item).
-			WithError(err).
-			Warn("failed to retrieve item")
+	if len(item.File) > 0 {
+		doCopy(ctx, id, c, source, item, r, store, upload)
+		return
+	}
+	doc := item.Document
+	if doc == nil || c.Images {
+		// Download URL and images
+		err := lim.fetch.run(ctx, func() error {
+			var err error
+			doc, err = retrieve(ctx, c, lim, item, out)
+			return err
+		})
+		if err != nil {
+			out.WithField("url", item.URL).
+				WithError(err).
+				Warn("failed to retrieve item")
+			markFailed(ctx, store, source, item, err)
+			return
+		}
+	}
+	out = out.WithField("slug", doc.Slug())
+	out.WithField("url", item.URL).Trace("item retrieved")
+	if c.Cover {
+		withCover, err := rm.AddCover(doc)
+		if err != nil {
+			out.WithError(err).Warn("failed to add cover page")
+		} else {
+			doc = withCover
+		}
+	}
+	name, basename, ok := nameItem(ctx, c, source, item, doc, store)
+	if !ok {
 		return
 	}
-	out = out.WithField("item", doc.Slug())
-	out.WithField("url", item).Trace("item retrieved")
 	// Convert document
-	basename := fmt.Sprintf("%s.epub", doc.Slug())
-	outPath := path.Join(c.WorkDir, basename)
+	outPath := path.Join(c.WorkDir, fmt.Sprintf("%s.%s", basename, c.Format))
 	out.WithField("path", outPath).Trace("converting item")
-	if err := rm.DocumentToEPUB(doc, outPath, c.Timeout); err != nil {
+	convert, _ := selectConverter(c)
+	err := lim.convert.run(ctx, func() error {
+		return convert(ctx, doc, outPath, c.Timeout)
+	})
+	if err != nil {
 		out.WithField("path", outPath).
 			WithError(err).
 			Warn("item conversion failed")
+		markFailed(ctx, store, source, item, err)
 		return
 	}
 	out.WithField("path", outPath).Trace("item converted")
 	// Upload
-	upload <- &document{ID: id, FilePath: outPath}
+	enqueue(ctx, upload, &document{ID: id, Source: source, Item: item, FilePath: outPath, Name: name, DestDir: r.Dest})
+}
+
+// retrieve downloads the item document, unless provided by the
+// source, embedding its images if configured to. Requests are
+// limited per host, so as not to hammer any site.
+func retrieve(ctx context.Context, c *conf, lim *limits, item *rm.Item, out *log.Entry) (rm.Document, error) {
+	doc := item.Document
+	if doc == nil {
+		out.WithField("url", item.URL).Trace("retrieving item")
+		err := lim.hosts.run(ctx, item.URL.Hostname(), func() error {
+			var err error
+			doc, err = rm.RetrieveContext(ctx, item.URL, c.Timeout)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if c.Images {
+		out.Trace("embedding images")
+		throttle := func(ctx context.Context, u *url.URL, fetch func() error) error {
+			return lim.hosts.run(ctx, u.Hostname(), fetch)
+		}
+		withImages, err := rm.EmbedImagesContext(ctx, doc, item.URL,
+			rm.MaxImages(c.MaxImages),
+			rm.MaxImageSize(c.MaxImageSize),
+			rm.ImageTimeout(c.Timeout),
+			rm.ImageThrottle(throttle))
+		if err != nil {
+			out.WithError(err).Warn("failed to embed images")
+		} else {
+			doc = withImages
+		}
+	}
+	return doc, nil
+}
+
+func nameItem(ctx context.Context, c *conf, source string, item *rm.Item, doc rm.Document, store *state.Store) (string, string, bool) {
+	out := log.WithFields(log.Fields{"source": source, "item": item.ID})
+	name, basename, err := c.namer.Name(doc, source, item.ID)
+	if err != nil {
+		out.WithError(err).Warn("failed to name item")
+		markFailed(ctx, store, source, item, err)
+		return "", "", false
+	}
+	if err := store.Update(source, item.ID, func(i *state.Item) {
+		i.Slug = basename
+	}); err != nil {
+		out.WithError(err).Warn("failed to update sync state")
+	}
+	return name, basename, true
+}
+
+// doCopy uploads an item file as is, since the reMarkable reads it
+// natively. The file is copied into the working directory so that
+// the source keeps ownership of the original.
+func doCopy(ctx context.Context, id uint64, c *conf, source string, item *rm.Item, r route, store *state.Store, upload chan<- *document) {
+	out := log.WithFields(log.Fields{"id": id, "source": source, "item": item.ID, "file": item.File})
+	doc := rm.NewHTMLDocument(item.URL, item.Title, "", rm.Metadata{Published: item.Added})
+	name, basename, ok := nameItem(ctx, c, source, item, doc, store)
+	if !ok {
+		return
+	}
+	outPath := path.Join(c.WorkDir, basename+strings.ToLower(path.Ext(item.File)))
+	if err := copyFile(item.File, outPath); err != nil {
+		out.WithError(err).Warn("failed to copy file")
+		markFailed(ctx, store, source, item, err)
+		return
+	}
+	out.WithField("path", outPath).Trace("file copied")
+	enqueue(ctx, upload, &document{ID: id, Source: source, Item: item, FilePath: outPath, Name: name, DestDir: r.Dest})
+}
+
+// enqueue hands doc over to the uploader, unless shutting down.
+func enqueue(ctx context.Context, upload chan<- *document, doc *document) {
+	select {
+	case upload <- doc:
+	case <-ctx.Done():
+		log.WithFields(log.Fields{"id": doc.ID, "item": doc.Item.ID}).
+			Trace("shutting down, item left pending")
+	}
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func newSources(c *conf) []rm.Source {
+	sources := []rm.Source{}
+	if len(c.PocketKey) > 0 || len(c.PocketToken) > 0 {
+		untag := []string{pocketTag}
+		for _, r := range c.Routes {
+			if len(r.Tag) > 0 {
+				untag = append(untag, r.Tag)
+			}
+		}
+		src := &pocket.Source{
+			Auth: &pocket.Auth{
+				ConsumerKey: c.PocketKey,
+				AccessToken: c.PocketToken,
+			},
+			Tag:     pocketTag,
+			Retag:   c.PocketRetag,
+			Untag:   untag,
+			Archive: c.PocketArchive,
+		}
+		// Routing needs item details and can't filter on a single tag
+		if len(c.Routes) > 0 {
+			src.Tag = ""
+		}
+		sources = append(sources, src)
+	}
+	for _, f := range c.Feeds {
+		u, _ := url.Parse(f)
+		sources = append(sources, &feed.Source{
+			URL:         u,
+			FullContent: c.FeedContent,
+			Timeout:     c.Timeout,
+		})
+	}
+	for _, dir := range c.Watch {
+		if abs, err := filepath.Abs(dir); err == nil {
+			dir = abs
+		}
+		sources = append(sources, &folder.Source{Dir: dir, MinAge: 5 * time.Second})
+	}
+	if len(c.WallabagURL) > 0 {
+		src := &wallabag.Source{
+			Client: &wallabag.Client{
+				BaseURL:      c.WallabagURL,
+				ClientID:     c.WallabagClientID,
+				ClientSecret: c.WallabagClientSecret,
+				Username:     c.WallabagUser,
+				Password:     c.WallabagPassword,
+				Timeout:      c.Timeout,
+			},
+			FullContent: c.WallabagContent,
+			Retag:       c.WallabagRetag,
+			Archive:     c.WallabagArchive,
+		}
+		if len(c.WallabagTag) > 0 {
+			src.Tags = []string{c.WallabagTag}
+			src.Untag = []string{c.WallabagTag}
+		}
+		sources = append(sources, src)
+	}
+	return sources
+}
+
+func doTail(ctx context.Context, c *conf, src rm.Source, store *state.Store, spawn func(string, *rm.Item, route), wg *sync.WaitGroup) {
+	defer wg.Done()
+	name := src.Name()
+	out := log.WithField("source", name)
+	tick := time.NewTicker(c.PollInterval)
+	defer tick.Stop()
+	out.Trace("start listening for new items")
+	defer out.Trace("stopped listening for new items")
+	for v := range src.Tail(ctx, rm.Cursor(store.Cursor(name)), tick.C) {
+		switch v := v.(type) {
+		case *rm.Item:
+			if i, ok := store.Get(name, v.ID); ok && i.Status == state.Uploaded {
+				out.WithField("item", v.ID).Trace("item already uploaded, skipping")
+				continue
+			} else if ok && !retryable(i) {
+				out.WithField("item", v.ID).Trace("item failed too many times, skipping")
+				continue
+			}
+			r, ok := selectRoute(c, name, v)
+			if !ok {
+				out.WithField("item", v.ID).Trace("item matches no route, skipping")
+				continue
+			}
+			out.WithFields(log.Fields{"item": v.ID, "dest": r.Dest}).Trace("item routed")
+			spawn(name, v, r)
+		case rm.Cursor:
+			if err := store.SetCursor(name, string(v)); err != nil {
+				out.WithError(err).Warn("failed to update sync state")
+			}
+		case error:
+			out.WithError(v).Warn("item processing failed, skipping")
+		default:
+			out.Warn("unexpected item, skipping")
+		}
+	}
 }
 
-func notifySignals(chans ...chan<- bool) {
+// withSignals returns a context cancelled on SIGINT or SIGTERM. Only
+// the first signal is handled, so that a second one terminates the
+// process right away.
+func withSignals(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
 	signals := make(chan os.Signal, 1)
-	signal.Notify(signals, os.Interrupt)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
 	go func() {
 		log.Trace("signal handler started")
 		defer log.Trace("signal handler exiting")
-		sig := <-signals
-		log.WithField("signal", sig).
-			Trace("signal handler received signal")
-		signal.Stop(signals)
-		for _, c := range chans {
-			c <- true
+		select {
+		case sig := <-signals:
+			log.WithField("signal", sig).
+				Trace("signal handler received signal")
+			cancel()
+		case <-ctx.Done():
 		}
+		signal.Stop(signals)
 	}()
+	return ctx, cancel
 }
 
-func rmConnect(c *conf) (*rm.Connection, error) {
+func rmConnect(ctx context.Context, c *conf) (*rm.Connection, error) {
+	onRefresh := rm.OnRefresh(func(userToken string, err error) {
+		if err != nil {
+			log.WithError(err).Warn("failed to renew reMarkable user token")
+			return
+		}
+		log.Trace("reMarkable user token renewed")
+		saveUserToken(c, userToken)
+	})
 	// First attempt with provided user token
-	rmConn, err := rm.NewConnection(c.RemarkableDeviceToken, c.RemarkableUserToken)
+	rmConn, err := rm.NewConnection(c.RemarkableDeviceToken, c.RemarkableUserToken, onRefresh)
 	if err != nil {
 		// First attempt errored, begin subsequent attempts
 		log.WithError(err).
@@ -154,14 +555,15 @@ func rmConnect(c *conf) (*rm.Connection, error) {
 		for i := 0; i < c.ConnectionAttempts; i++ {
 			log := log.WithFields(log.Fields{"attempt": i + 1, "limit": c.ConnectionAttempts})
 			log.Trace("requesting a new reMarkable user token")
-			c.RemarkableUserToken, err = rm.NewUserToken(c.RemarkableDeviceToken)
+			c.RemarkableUserToken, err = rm.NewUserTokenContext(ctx, c.RemarkableDeviceToken)
 			if err != nil {
 				log.WithError(err).Trace("new user token request failed")
 				continue
 			}
 			log.Trace("connecting to reMarkable cloud")
-			rmConn, err = rm.NewConnection(c.RemarkableDeviceToken, c.RemarkableUserToken)
+			rmConn, err = rm.NewConnection(c.RemarkableDeviceToken, c.RemarkableUserToken, onRefresh)
 			if err == nil {
+				saveUserToken(c, c.RemarkableUserToken)
 				break
 			}
 			log.WithError(err).
@@ -174,63 +576,149 @@ func rmConnect(c *conf) (*rm.Connection, error) {
 	return rmConn, nil
 }
 
-func appMain(c *conf) error {
+func appMain(ctx context.Context, c *conf) error {
+	if _, err := selectConverter(c); err != nil {
+		return err
+	}
 	// Ensure we have external commands
-	if _, err := exec.LookPath("pandoc"); err != nil {
+	if usesPandoc(c) {
+		if _, err := exec.LookPath("pandoc"); err != nil {
+			return err
+		}
+	}
+	if c.Format == "pdf" {
+		if _, err := exec.LookPath(rm.DefaultPDFEngine); err != nil {
+			return fmt.Errorf("PDF output requires LaTeX: %w", err)
+		}
+	}
+	log.WithField("path", c.StateDir).Trace("opening sync state")
+	store, err := state.Open(c.StateDir)
+	if err != nil {
 		return err
 	}
 	log.Trace("connecting to reMarkable cloud")
-	rmConn, err := rmConnect(c)
+	rmConn, err := rmConnect(ctx, c)
 	if err != nil {
 		log.WithError(err).Fatal("cannot connect to reMarkable cloud")
 	}
 	log.Trace("connected to reMarkable cloud")
-	// Create downstream destination directory
-	log.WithField("path", c.DestDir).
-		Trace("creating reMarkable destination directory")
-	if err := rmConn.MkDir(c.DestDir); err != nil {
-		log.WithError(err).
-			Fatal("creation of reMarkable destination directory failed")
+	// Create downstream destination directories
+	dests := []string{c.DestDir}
+	for _, r := range c.Routes {
+		dests = append(dests, r.Dest)
 	}
-	log.WithField("path", c.DestDir).
-		Trace("reMarkable destination directory created")
-	// Spawn item producer
-	pocketConn := &pocket.Auth{
-		ConsumerKey: c.PocketKey,
-		AccessToken: c.PocketToken,
+	for _, dest := range dests {
+		log.WithField("path", dest).
+			Trace("creating reMarkable destination directory")
+		if err := rmConn.MkDirContext(ctx, dest); err != nil {
+			log.WithError(err).
+				Fatal("creation of reMarkable destination directory failed")
+		}
+		log.WithField("path", dest).
+			Trace("reMarkable destination directory created")
+	}
+	sources := newSources(c)
+	if len(sources) <= 0 {
+		return errors.New("no sources configured")
 	}
-	tailerTick := time.NewTicker(c.PollInterval)
-	tailerStop := make(chan bool, 1)
 	var wg sync.WaitGroup
 	wg.Add(1)
-	uploaderIn, uploaderStop := doUpload(c, rmConn, &wg)
-	notifySignals(tailerStop, uploaderStop)
-	defer func() {
-		uploaderStop <- true
-		tailerStop <- true
-		tailerTick.Stop()
-	}()
-	opts := pocket.NewRetrieveOptions(pocket.WithTag("rm"), pocket.Unread)
+	uploaderIn := doUpload(ctx, c, rmConn, store, &wg)
+	workers := newPool(ctx, c, store, uploaderIn, &wg)
 	var id uint64 = 0
-	log.Trace("start listening for new items")
-	for item := range pocketConn.Tail(opts, tailerTick.C, tailerStop) {
-		switch v := item.(type) {
-		case *url.URL:
-			wg.Add(1)
-			go doRetrieve(id, c, v, uploaderIn, &wg)
-			id++
-		case error:
-			log.WithError(v).Warn("item processing failed, skipping")
-		default:
-			log.Warn("unexpected item, skipping")
+	spawn := func(source string, item *rm.Item, r route) {
+		err := store.Update(source, item.ID, func(i *state.Item) {
+			i.URL = item.URL.String()
+			i.Dest = r.Dest
+			i.Status = state.Pending
+		})
+		if err != nil {
+			log.WithError(err).Warn("failed to update sync state")
+		}
+		workers.submit(ctx, job{id: atomic.AddUint64(&id, 1) - 1, source: source, item: item, route: r})
+	}
+	// Resume items left pending by a previous run, and retry failed
+	// ones: sources won't emit them again once past their cursor
+	bySource := make(map[string]rm.Source, len(sources))
+	for _, src := range sources {
+		bySource[src.Name()] = src
+	}
+	for _, i := range store.Items() {
+		if !retryable(i) {
+			continue
+		}
+		log := log.WithFields(log.Fields{"source": i.Source, "item": i.ID, "status": i.Status})
+		src, ok := bySource[i.Source]
+		if !ok {
+			log.Warn("item from unknown source, skipping")
+			continue
+		}
+		u, err := url.Parse(i.URL)
+		if err != nil {
+			log.WithError(err).Warn("item with invalid URL, skipping")
+			continue
+		}
+		item, err := src.Resume(ctx, i.ID, u)
+		if err != nil && i.Status == state.Failed {
+			// e.g. files already moved away by the folder source
+			log.WithError(err).Trace("cannot retry failed item, skipping")
+			continue
+		} else if err != nil {
+			log.WithError(err).Warn("cannot resume item, skipping")
+			continue
 		}
+		r := route{Dest: i.Dest}
+		if len(r.Dest) <= 0 {
+			r.Dest = c.DestDir
+		}
+		log.Trace("resuming item")
+		spawn(i.Source, item, r)
+	}
+	// Spawn item producers
+	var tailers sync.WaitGroup
+	for _, src := range sources {
+		tailers.Add(1)
+		go doTail(ctx, c, src, store, spawn, &tailers)
 	}
+	tailers.Wait()
+	workers.close()
 	log.Trace("waiting for remaining workers to exit")
 	wg.Wait()
 	log.Trace("all workers exited")
 	return nil
 }
 
+// run wraps f into an action that sets up logging, the
+// configuration and the working directory shared by all commands.
+func run(f func(*cli.Context, *conf) error) cli.ActionFunc {
+	return func(ctx *cli.Context) error {
+		log.SetLevel(log.WarnLevel)
+		if ctx.Bool("verbose") {
+			log.SetLevel(log.TraceLevel)
+		}
+		c, err := newConf(ctx)
+		if err != nil {
+			return err
+		}
+		tmpdir, err := ioutil.TempDir("", "rmd")
+		if err != nil {
+			log.WithField("path", tmpdir).Fatal("failed to create working directory")
+		}
+		log.WithField("path", tmpdir).Trace("working directory created")
+		c.WorkDir = tmpdir
+		if !c.Keep {
+			defer func() {
+				if err := os.RemoveAll(tmpdir); err != nil {
+					log.WithField("path", tmpdir).Warn("failed to remove working directory")
+				} else {
+					log.WithField("path", tmpdir).Trace("working directory removed")
+				}
+			}()
+		}
+		return f(ctx, c)
+	}
+}
+
 func main() {
 	app := &cli.App{
 		Name:     "rmd",
@@ -238,6 +726,17 @@ func main() {
 		Version:  "v0.1a",
 		Compiled: time.Now(),
 		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "config",
+				Aliases: []string{"c"},
+				Usage:   "Load configuration from YAML `FILE`; flags and environment take precedence",
+				EnvVars: []string{"RMD_CONFIG"},
+			},
+			&cli.StringFlag{
+				Name:    "credentials",
+				Usage:   "Load and save credentials obtained by rmd auth in YAML `FILE` (default: rmd/credentials.yaml in the user configuration directory)",
+				EnvVars: []string{"RMD_CREDENTIALS"},
+			},
 			&cli.StringFlag{
 				Name:    "dest",
 				Aliases: []string{"d"},
@@ -252,6 +751,41 @@ func main() {
 				EnvVars: []string{"RMD_INTERVAL"},
 				Value:   10 * time.Second,
 			},
+			&cli.StringFlag{
+				Name:    "format",
+				Aliases: []string{"f"},
+				Usage:   "Upload documents as `FORMAT`, either epub or pdf (via pandoc)",
+				EnvVars: []string{"RMD_FORMAT"},
+				Value:   "epub",
+			},
+			&cli.StringFlag{
+				Name:    "converter",
+				Usage:   "Use `NAME` to convert articles to EPUB, either native or pandoc",
+				EnvVars: []string{"RMD_CONVERTER"},
+				Value:   "pandoc",
+			},
+			&cli.BoolFlag{
+				Name:    "images",
+				Usage:   "Download article images and embed them into documents",
+				EnvVars: []string{"RMD_IMAGES"},
+			},
+			&cli.IntFlag{
+				Name:    "max-images",
+				Usage:   "Embed at most `NUM` images per document",
+				EnvVars: []string{"RMD_MAX_IMAGES"},
+				Value:   50,
+			},
+			&cli.Int64Flag{
+				Name:    "max-image-size",
+				Usage:   "Skip images larger than `BYTES`",
+				EnvVars: []string{"RMD_MAX_IMAGE_SIZE"},
+				Value:   5 << 20,
+			},
+			&cli.BoolFlag{
+				Name:    "cover",
+				Usage:   "Add a cover page with title, author, date and a QR code linking to the original article",
+				EnvVars: []string{"RMD_COVER"},
+			},
 			&cli.StringFlag{
 				Name:    "rm-device",
 				Usage:   "Use `STRING` as reMarkable cloud API device token",
@@ -272,6 +806,95 @@ func main() {
 				Usage:   "Use `STRING` as Pocket API consumer key",
 				EnvVars: []string{"RMD_POCKET_KEY"},
 			},
+			&cli.BoolFlag{
+				Name:    "pocket-archive",
+				Usage:   "Archive Pocket items once uploaded to reMarkable cloud",
+				EnvVars: []string{"RMD_POCKET_ARCHIVE"},
+			},
+			&cli.StringFlag{
+				Name:    "pocket-retag",
+				Usage:   "Replace the rm tag with `TAG` on Pocket items once uploaded to reMarkable cloud",
+				EnvVars: []string{"RMD_POCKET_RETAG"},
+			},
+			&cli.StringFlag{
+				Name:    "name",
+				Usage:   "Name documents after `TEMPLATE`, a Go template with .Title, .Site, .Author, .Date, .Domain, .ID and .Hash fields",
+				EnvVars: []string{"RMD_NAME"},
+				Value:   rm.DefaultNameTemplate,
+			},
+			&cli.StringFlag{
+				Name:    "on-conflict",
+				Usage:   "When a document with the same name already exists, apply `POLICY`: skip, overwrite or rename",
+				EnvVars: []string{"RMD_ON_CONFLICT"},
+				Value:   "skip",
+			},
+			&cli.StringSliceFlag{
+				Name:    "route",
+				Aliases: []string{"r"},
+				Usage:   "Send items matching `RULE` (e.g. tag:rm-work=/Work, domain:arxiv.org=/Papers, favorite+tag:rm=/Favorites) to its folder instead of --dest; may be repeated",
+				EnvVars: []string{"RMD_ROUTES"},
+			},
+			&cli.StringSliceFlag{
+				Name:    "feed",
+				Usage:   "Sync new entries of the RSS or Atom feed at `URL`; may be repeated",
+				EnvVars: []string{"RMD_FEEDS"},
+			},
+			&cli.BoolFlag{
+				Name:    "feed-content",
+				Usage:   "Use the full content provided by feeds, when available, instead of retrieving entry pages",
+				EnvVars: []string{"RMD_FEED_CONTENT"},
+			},
+			&cli.StringSliceFlag{
+				Name:    "watch",
+				Usage:   "Sync HTML, Markdown, PDF and EPUB files dropped into `DIR`; may be repeated",
+				EnvVars: []string{"RMD_WATCH"},
+			},
+			&cli.StringFlag{
+				Name:    "wallabag-url",
+				Usage:   "Sync unread entries of the Wallabag instance at `URL`",
+				EnvVars: []string{"RMD_WALLABAG_URL"},
+			},
+			&cli.StringFlag{
+				Name:    "wallabag-client-id",
+				Usage:   "Use `STRING` as Wallabag API client ID",
+				EnvVars: []string{"RMD_WALLABAG_CLIENT_ID"},
+			},
+			&cli.StringFlag{
+				Name:    "wallabag-client-secret",
+				Usage:   "Use `STRING` as Wallabag API client secret",
+				EnvVars: []string{"RMD_WALLABAG_CLIENT_SECRET"},
+			},
+			&cli.StringFlag{
+				Name:    "wallabag-user",
+				Usage:   "Use `STRING` as Wallabag user name",
+				EnvVars: []string{"RMD_WALLABAG_USER"},
+			},
+			&cli.StringFlag{
+				Name:    "wallabag-password",
+				Usage:   "Use `STRING` as Wallabag password",
+				EnvVars: []string{"RMD_WALLABAG_PASSWORD"},
+			},
+			&cli.StringFlag{
+				Name:    "wallabag-tag",
+				Usage:   "Sync only Wallabag entries tagged with `TAG`; if empty, all unread entries are synced",
+				EnvVars: []string{"RMD_WALLABAG_TAG"},
+				Value:   pocketTag,
+			},
+			&cli.BoolFlag{
+				Name:    "wallabag-content",
+				Usage:   "Use the content already extracted by Wallabag instead of retrieving entry pages",
+				EnvVars: []string{"RMD_WALLABAG_CONTENT"},
+			},
+			&cli.BoolFlag{
+				Name:    "wallabag-archive",
+				Usage:   "Archive Wallabag entries once uploaded to reMarkable cloud",
+				EnvVars: []string{"RMD_WALLABAG_ARCHIVE"},
+			},
+			&cli.StringFlag{
+				Name:    "wallabag-retag",
+				Usage:   "Replace the sync tag with `TAG` on Wallabag entries once uploaded to reMarkable cloud",
+				EnvVars: []string{"RMD_WALLABAG_RETAG"},
+			},
 			&cli.DurationFlag{
 				Name:    "timeout",
 				Aliases: []string{"t"},
@@ -279,6 +902,39 @@ func main() {
 				EnvVars: []string{"RMD_TIMEOUT"},
 				Value:   30 * time.Second,
 			},
+			&cli.IntFlag{
+				Name:    "workers",
+				Usage:   "Process up to `NUM` items at once",
+				EnvVars: []string{"RMD_WORKERS"},
+				Value:   4,
+			},
+			&cli.IntFlag{
+				Name:    "fetch-workers",
+				Usage:   "Retrieve up to `NUM` items at once; 0 means up to --workers",
+				EnvVars: []string{"RMD_FETCH_WORKERS"},
+			},
+			&cli.IntFlag{
+				Name:    "convert-workers",
+				Usage:   "Convert up to `NUM` items at once; 0 means up to --workers",
+				EnvVars: []string{"RMD_CONVERT_WORKERS"},
+			},
+			&cli.IntFlag{
+				Name:    "host-workers",
+				Usage:   "Retrieve up to `NUM` items at once from the same host; 0 means no limit",
+				EnvVars: []string{"RMD_HOST_WORKERS"},
+				Value:   2,
+			},
+			&cli.IntFlag{
+				Name:    "queue",
+				Usage:   "Queue up to `NUM` items waiting for a worker before pausing sources",
+				EnvVars: []string{"RMD_QUEUE"},
+				Value:   20,
+			},
+			&cli.StringFlag{
+				Name:    "state-dir",
+				Usage:   "Persist sync state into `DIR`; if not provided, state is kept in memory only",
+				EnvVars: []string{"RMD_STATE_DIR"},
+			},
 			&cli.IntFlag{
 				Name:    "retry",
 				Usage:   "Use `NUM` as the maximum number of connection attempts to reMarkable cloud",
@@ -297,46 +953,79 @@ func main() {
 				EnvVars: []string{"RMD_VERBOSE"},
 			},
 		},
-		Action: func(ctx *cli.Context) error {
-			log.SetLevel(log.WarnLevel)
-			if ctx.Bool("verbose") {
-				log.SetLevel(log.TraceLevel)
-			}
-			tmpdir, err := ioutil.TempDir("", "rmd")
-			if err != nil {
-				log.WithField("path", tmpdir).Fatal("failed to create working directory")
-			}
-			log.WithField("path", tmpdir).Trace("working directory created")
-			ctx.Deadline()
-			c := &conf{
-				ConnectionAttempts:    ctx.Int("retry"),
-				Keep:                  ctx.Bool("keep"),
-				Timeout:               ctx.Duration("timeout"),
-				PollInterval:          ctx.Duration("interval"),
-				WorkDir:               tmpdir,
-				DestDir:               ctx.String("dest"),
-				RemarkableDeviceToken: ctx.String("rm-device"),
-				RemarkableUserToken:   ctx.String("rm-user"),
-				PocketKey:             ctx.String("pocket-key"),
-				PocketToken:           ctx.String("pocket-token"),
-			}
-			if !c.Keep {
-				defer func() {
-					if err := os.RemoveAll(tmpdir); err != nil {
-						log.WithField("path", tmpdir).Warn("failed to remove working directory")
-					} else {
-						log.WithField("path", tmpdir).Trace("working directory removed")
-					}
-				}()
-			}
-			return appMain(c)
+		Action: run(func(ctx *cli.Context, c *conf) error {
+			return appMain(ctx.Context, c)
+		}),
+		Commands: []*cli.Command{
+			{
+				Name:  "config",
+				Usage: "Inspect the configuration",
+				Subcommands: []*cli.Command{
+					{
+						Name:   "check",
+						Usage:  "Validate and print the effective configuration, secrets redacted",
+						Action: configCheck,
+					},
+				},
+			},
+			{
+				Name:  "auth",
+				Usage: "Obtain credentials, saving them into the credentials file",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "pocket",
+						Usage: "Authorize rmd to access a Pocket account (requires --pocket-key)",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:  "listen",
+								Usage: "Listen on `ADDRESS` for the authorization callback",
+								Value: "localhost:0",
+							},
+							&cli.DurationFlag{
+								Name:  "wait",
+								Usage: "Wait at most `DURATION` for the authorization to be granted",
+								Value: 5 * time.Minute,
+							},
+						},
+						Action: run(authPocket),
+					},
+					{
+						Name:      "remarkable",
+						Usage:     "Register rmd as a device on the reMarkable cloud",
+						ArgsUsage: "[CODE]",
+						Action:    run(authRemarkable),
+					},
+				},
+			},
+			{
+				Name:  "highlights",
+				Usage: "Export highlights of uploaded documents as Markdown notes and Pocket tags",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    "notes",
+						Usage:   "Write a Markdown note per highlighted document into `DIR`",
+						EnvVars: []string{"RMD_NOTES"},
+					},
+					&cli.StringFlag{
+						Name:    "pocket-tag",
+						Usage:   "Tag highlighted items with `STRING` on Pocket; if empty, Pocket is left untouched",
+						EnvVars: []string{"RMD_POCKET_HIGHLIGHT_TAG"},
+						Value:   "rm-highlighted",
+					},
+				},
+				Action: run(func(ctx *cli.Context, c *conf) error {
+					return highlightsMain(ctx.Context, c, ctx.String("notes"), ctx.String("pocket-tag"))
+				}),
+			},
 		},
 	}
 	cli.VersionFlag = &cli.BoolFlag{
 		Name:  "version",
 		Usage: "print the version and exit",
 	}
-	err := app.Run(os.Args)
+	ctx, cancel := withSignals(context.Background())
+	defer cancel()
+	err := app.RunContext(ctx, os.Args)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"testing"
+
+	"github.com/nazavode/rm"
+	"github.com/nazavode/rm/state"
+	log "github.com/sirupsen/logrus"
+)
+
+func TestPutRetrying(t *testing.T) {
+	first, second := &rm.Connection{}, &rm.Connection{}
+	for _, tc := range []struct {
+		name    string
+		results []error
+		conn    *rm.Connection
+		id      string
+		err     error
+	}{
+		{"success", []error{nil}, first, "doc", nil},
+		{"exists", []error{rm.ErrAlreadyExists}, first, "doc", nil},
+		{"retry success", []error{rm.ErrApi, nil}, second, "doc", nil},
+		{"retry exists", []error{rm.ErrApi, rm.ErrAlreadyExists}, second, "doc", nil},
+		{"retry failure", []error{rm.ErrApi, rm.ErrApi}, second, "", rm.ErrApi},
+		{"other failure", []error{rm.ErrNotFound}, first, "", rm.ErrNotFound},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			calls := 0
+			put := func(conn *rm.Connection) (*rm.Entry, error) {
+				if calls > 0 && conn != second {
+					t.Error("retry not on the new connection")
+				}
+				err := tc.results[calls]
+				calls++
+				if err != nil && !errors.Is(err, rm.ErrAlreadyExists) {
+					return nil, fmt.Errorf("put: %w", err)
+				}
+				return &rm.Entry{ID: "doc"}, err
+			}
+			reconnect := func() (*rm.Connection, error) {
+				return second, nil
+			}
+			conn, id, err := putRetrying(context.Background(), log.NewEntry(log.StandardLogger()), first, put, reconnect)
+			if !errors.Is(err, tc.err) || (err == nil) != (tc.err == nil) {
+				t.Errorf("got error %v, want %v", err, tc.err)
+			}
+			if id != tc.id {
+				t.Errorf("got document %q, want %q", id, tc.id)
+			}
+			if conn != tc.conn {
+				t.Error("unexpected connection returned")
+			}
+			if calls != len(tc.results) {
+				t.Errorf("got %d put calls, want %d", calls, len(tc.results))
+			}
+		})
+	}
+}
+
+func TestPutRetryingReconnectFailure(t *testing.T) {
+	first := &rm.Connection{}
+	put := func(conn *rm.Connection) (*rm.Entry, error) {
+		return nil, rm.ErrApi
+	}
+	failure := errors.New("no connection")
+	reconnect := func() (*rm.Connection, error) {
+		return nil, failure
+	}
+	conn, _, err := putRetrying(context.Background(), log.NewEntry(log.StandardLogger()), first, put, reconnect)
+	if !errors.Is(err, failure) {
+		t.Errorf("got error %v, want %v", err, failure)
+	}
+	if conn != first {
+		t.Error("connection replaced despite reconnection failure")
+	}
+}
+
+func TestResolveConflict(t *testing.T) {
+	store, err := state.Open("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := func(id, url, documentID string) {
+		if err := store.Update("pocket", id, func(i *state.Item) {
+			i.URL = url
+			i.DocumentID = documentID
+			i.Status = state.Uploaded
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	record("1", "https://example.com/a", "doc-a")
+	record("2", "https://example.com/b", "doc-b")
+	target, _ := url.Parse("https://example.com/a")
+	doc := &document{Source: "pocket", Item: &rm.Item{ID: "1", URL: target}}
+	for _, tc := range []struct {
+		name     string
+		doc      *document
+		existing string
+		want     rm.ConflictPolicy
+	}{
+		{"untracked", doc, "doc-unknown", rm.Rename},
+		{"same item", doc, "doc-a", rm.Skip},
+		{"same URL", &document{Source: "wallabag", Item: &rm.Item{ID: "7", URL: target}}, "doc-a", rm.Skip},
+		{"different URL", doc, "doc-b", rm.Rename},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, policy := range []rm.ConflictPolicy{rm.Skip, rm.Overwrite} {
+				want := tc.want
+				if want != rm.Rename {
+					want = policy
+				}
+				if got := resolveConflict(policy, store, tc.doc, tc.existing); got != want {
+					t.Errorf("got %v with policy %v, want %v", got, policy, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	for _, tc := range []struct {
+		item state.Item
+		want bool
+	}{
+		{state.Item{Status: state.Pending}, true},
+		{state.Item{Status: state.Uploaded}, false},
+		{state.Item{Status: state.Failed, Attempts: 1}, true},
+		{state.Item{Status: state.Failed, Attempts: maxAttempts}, false},
+	} {
+		if got := retryable(tc.item); got != tc.want {
+			t.Errorf("retryable(%+v) = %v, want %v", tc.item, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	"github.com/nazavode/rm"
+	"github.com/nazavode/rm/state"
+	log "github.com/sirupsen/logrus"
+)
+
+// semaphore bounds the number of goroutines running at once; a nil
+// one doesn't bound anything.
+type semaphore chan struct{}
+
+func newSemaphore(n int) semaphore {
+	if n <= 0 {
+		return nil
+	}
+	return make(semaphore, n)
+}
+
+// run calls f as soon as a slot is available, unless ctx is done
+// first.
+func (s semaphore) run(ctx context.Context, f func() error) error {
+	if s == nil {
+		return f()
+	}
+	select {
+	case s <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-s }()
+	return f()
+}
+
+// hostLimiter bounds the number of concurrent requests to each host.
+type hostLimiter struct {
+	limit int
+	mu    sync.Mutex
+	hosts map[string]*hostSlots
+}
+
+type hostSlots struct {
+	semaphore
+	// users counts the requests running or waiting, so that idle
+	// hosts can be forgotten
+	users int
+}
+
+// run calls f within the limits of host; requests not bound to any
+// host, e.g. to local files, aren't limited.
+func (h *hostLimiter) run(ctx context.Context, host string, f func() error) error {
+	if h.limit <= 0 || len(host) <= 0 {
+		return f()
+	}
+	h.mu.Lock()
+	if h.hosts == nil {
+		h.hosts = make(map[string]*hostSlots)
+	}
+	slots, ok := h.hosts[host]
+	if !ok {
+		slots = &hostSlots{semaphore: newSemaphore(h.limit)}
+		h.hosts[host] = slots
+	}
+	slots.users++
+	h.mu.Unlock()
+	defer func() {
+		h.mu.Lock()
+		if slots.users--; slots.users <= 0 {
+			delete(h.hosts, host)
+		}
+		h.mu.Unlock()
+	}()
+	return slots.run(ctx, f)
+}
+
+// limits bounds the concurrency of the retrieval and conversion
+// stages, on top of the number of workers.
+type limits struct {
+	fetch   semaphore
+	convert semaphore
+	hosts   *hostLimiter
+}
+
+func newLimits(c *conf) *limits {
+	return &limits{
+		fetch:   newSemaphore(c.FetchWorkers),
+		convert: newSemaphore(c.ConvertWorkers),
+		hosts:   &hostLimiter{limit: c.HostWorkers},
+	}
+}
+
+type job struct {
+	id     uint64
+	source string
+	item   *rm.Item
+	route  route
+}
+
+// pool processes items with a fixed number of workers, fed through a
+// bounded queue: once the queue is full, submitting blocks and so
+// does the source tailer, until workers catch up.
+type pool struct {
+	jobs chan job
+}
+
+func newPool(ctx context.Context, c *conf, store *state.Store, upload chan<- *document, wg *sync.WaitGroup) *pool {
+	p := &pool{jobs: make(chan job, c.QueueSize)}
+	lim := newLimits(c)
+	for i := 0; i < c.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case j, ok := <-p.jobs:
+					if !ok {
+						return
+					}
+					doRetrieve(ctx, j.id, c, lim, j.source, j.item, j.route, store, upload)
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	return p
+}
+
+// submit queues j, waiting for room unless ctx is done first, in
+// which case the item is left pending.
+func (p *pool) submit(ctx context.Context, j job) {
+	select {
+	case p.jobs <- j:
+	case <-ctx.Done():
+		log.WithFields(log.Fields{"source": j.source, "item": j.item.ID}).
+			Trace("shutting down, item left pending")
+	}
+}
+
+// close stops the workers once the queue is drained; no jobs can be
+// submitted afterwards.
+func (p *pool) close() {
+	close(p.jobs)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHostLimiter(t *testing.T) {
+	ctx := context.Background()
+	h := &hostLimiter{limit: 2}
+	var mu sync.Mutex
+	running, peak := map[string]int{}, map[string]int{}
+	started := map[string]chan bool{"a": make(chan bool, 3), "b": make(chan bool, 3)}
+	release := make(chan struct{})
+	var wg sync.WaitGroup
+	for _, host := range []string{"a", "a", "a", "b", "b", "b"} {
+		wg.Add(1)
+		go func(host string) {
+			defer wg.Done()
+			h.run(ctx, host, func() error {
+				mu.Lock()
+				running[host]++
+				if running[host] > peak[host] {
+					peak[host] = running[host]
+				}
+				mu.Unlock()
+				started[host] <- true
+				<-release
+				mu.Lock()
+				running[host]--
+				mu.Unlock()
+				return nil
+			})
+		}(host)
+	}
+	// Two requests per host get through, the third one waits
+	for _, host := range []string{"a", "b"} {
+		<-started[host]
+		<-started[host]
+	}
+	for _, host := range []string{"a", "b"} {
+		select {
+		case <-started[host]:
+			t.Errorf("got a third concurrent request to %s", host)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+	close(release)
+	wg.Wait()
+	for _, host := range []string{"a", "b"} {
+		if peak[host] != 2 {
+			t.Errorf("got %d concurrent requests to %s, want 2", peak[host], host)
+		}
+	}
+	if len(h.hosts) > 0 {
+		t.Errorf("got %d hosts left, want none", len(h.hosts))
+	}
+}
+
+func TestHostLimiterNoHost(t *testing.T) {
+	h := &hostLimiter{limit: 1}
+	// Requests with no host don't wait for each other
+	err := h.run(context.Background(), "", func() error {
+		return h.run(context.Background(), "", func() error { return nil })
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.hosts != nil {
+		t.Errorf("got hosts %v, want none", h.hosts)
+	}
+}
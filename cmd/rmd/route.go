@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/nazavode/rm"
+	"github.com/nazavode/rm/pocket"
+)
+
+// route sends the Pocket items matching all of its criteria to
+// the Dest directory. When retagging on Pocket, the tags of all
+// routes are removed from uploaded items, along with the sync tag.
+type route struct {
+	Tag      string `yaml:"tag,omitempty"`
+	Domain   string `yaml:"domain,omitempty"`
+	Favorite bool   `yaml:"favorite,omitempty"`
+	Dest     string `yaml:"dest"`
+}
+
+// parseRoute parses routes in the CRITERION[+CRITERION...]=DEST form,
+// where CRITERION is one of tag:NAME, domain:HOST or favorite.
+func parseRoute(s string) (route, error) {
+	r := route{}
+	i := strings.LastIndex(s, "=")
+	if i < 0 {
+		return r, fmt.Errorf("route %q: missing destination", s)
+	}
+	r.Dest = strings.TrimSpace(s[i+1:])
+	for _, criterion := range strings.Split(s[:i], "+") {
+		kind := strings.TrimSpace(criterion)
+		value := ""
+		if j := strings.Index(kind, ":"); j >= 0 {
+			kind, value = strings.TrimSpace(kind[:j]), strings.TrimSpace(kind[j+1:])
+		}
+		switch kind {
+		case "tag":
+			r.Tag = value
+		case "domain":
+			r.Domain = value
+		case "favorite":
+			r.Favorite = true
+		default:
+			return r, fmt.Errorf("route %q: unknown criterion %q", s, kind)
+		}
+	}
+	return r, r.validate()
+}
+
+func (r route) validate() error {
+	if len(r.Tag) <= 0 && len(r.Domain) <= 0 && !r.Favorite {
+		return errors.New("route needs at least one of tag, domain or favorite")
+	}
+	if !strings.HasPrefix(r.Dest, "/") {
+		return fmt.Errorf("route destination must be an absolute path, got %q", r.Dest)
+	}
+	return nil
+}
+
+func (r route) matches(item *rm.Item) bool {
+	if len(r.Tag) > 0 && !item.HasTag(r.Tag) {
+		return false
+	}
+	if r.Favorite && !item.Favorite {
+		return false
+	}
+	if len(r.Domain) > 0 {
+		if item.URL == nil {
+			return false
+		}
+		host := strings.ToLower(item.URL.Hostname())
+		domain := strings.ToLower(r.Domain)
+		if host != domain && !strings.HasSuffix(host, "."+domain) {
+			return false
+		}
+	}
+	return true
+}
+
+// selectRoute returns the first route matching item, falling back
+// to the default destination. Pocket items need to be tagged with
+// pocketTag to fall back, as with routes all of them are retrieved.
+func selectRoute(c *conf, source string, item *rm.Item) (route, bool) {
+	for _, r := range c.Routes {
+		if r.matches(item) {
+			return r, true
+		}
+	}
+	if len(c.Routes) <= 0 || source != pocket.SourceName || item.HasTag(pocketTag) {
+		return route{Tag: pocketTag, Dest: c.DestDir}, true
+	}
+	return route{}, false
+}
@@ -0,0 +1,69 @@
+package rm
+
+import (
+	"fmt"
+	"html"
+	"strings"
+
+	"rsc.io/qr"
+)
+
+const coverQRCode = "cover/qrcode.png"
+
+type coverDocument interface {
+	Cover() string
+}
+
+func coverOf(d Document) string {
+	if c, ok := d.(coverDocument); ok {
+		return c.Cover()
+	}
+	return ""
+}
+
+type documentWithCover struct {
+	Document
+	cover     string
+	resources []Resource
+}
+
+func (d *documentWithCover) Cover() string {
+	return d.cover
+}
+
+func (d *documentWithCover) Resources() []Resource {
+	return append(resourcesOf(d.Document), d.resources...)
+}
+
+// AddCover returns a Document carrying a cover page made of the
+// title, site name, author, publication date and a QR code that
+// links back to the original article.
+func AddCover(d Document) (Document, error) {
+	meta := d.Metadata()
+	var cover strings.Builder
+	paragraph := func(class, text string) {
+		if len(text) > 0 {
+			fmt.Fprintf(&cover, "<p class=\"%s\">%s</p>\n", class, html.EscapeString(text))
+		}
+	}
+	cover.WriteString("<div class=\"cover\">\n")
+	paragraph("cover-site", meta.SiteName)
+	fmt.Fprintf(&cover, "<h1 class=\"cover-title\">%s</h1>\n", html.EscapeString(d.Title()))
+	paragraph("cover-author", meta.Author)
+	if !meta.Published.IsZero() {
+		paragraph("cover-date", meta.Published.Format("January 2, 2006"))
+	}
+	resources := []Resource{}
+	if len(meta.SourceURL) > 0 {
+		code, err := qr.Encode(meta.SourceURL, qr.M)
+		if err != nil {
+			return nil, fmt.Errorf("cannot encode source URL as QR code: %w", err)
+		}
+		code.Scale = 6
+		resources = append(resources, Resource{Name: coverQRCode, MediaType: "image/png", Data: code.PNG()})
+		fmt.Fprintf(&cover, "<p class=\"cover-qrcode\"><img src=\"%s\" alt=\"QR code\"/></p>\n", coverQRCode)
+		paragraph("cover-url", meta.SourceURL)
+	}
+	cover.WriteString("</div>\n")
+	return &documentWithCover{Document: d, cover: cover.String(), resources: resources}, nil
+}
@@ -0,0 +1,102 @@
+// Package credentials stores the secrets obtained by rmd auth, apart
+// from the configuration file so that the latter can be shared, and
+// used by rmctl as well.
+package credentials
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v2"
+)
+
+type Credentials struct {
+	RemarkableDeviceToken string `yaml:"rm_device,omitempty"`
+	RemarkableUserToken   string `yaml:"rm_user,omitempty"`
+	PocketKey             string `yaml:"pocket_key,omitempty"`
+	PocketToken           string `yaml:"pocket_token,omitempty"`
+}
+
+// DefaultPath returns the default credentials file location,
+// rmd/credentials.yaml in the user configuration directory, or an
+// empty string if there's none.
+func DefaultPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "rmd", "credentials.yaml")
+}
+
+// Load reads the credentials file at p; a missing file, or an empty
+// p, holds no credentials.
+func Load(p string) (*Credentials, error) {
+	cr := &Credentials{}
+	if len(p) <= 0 {
+		return cr, nil
+	}
+	content, err := ioutil.ReadFile(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return cr, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("cannot read credentials file: %w", err)
+	}
+	if err := yaml.UnmarshalStrict(content, cr); err != nil {
+		return nil, fmt.Errorf("invalid credentials file %s: %w", p, err)
+	}
+	return cr, nil
+}
+
+// Save updates the credentials file at p, readable by the current
+// user only.
+func Save(p string, update func(*Credentials)) error {
+	if len(p) <= 0 {
+		return errors.New("no credentials file location available, use --credentials")
+	}
+	cr, err := Load(p)
+	if err != nil {
+		return err
+	}
+	update(cr)
+	content, err := yaml.Marshal(cr)
+	if err != nil {
+		return fmt.Errorf("cannot marshal credentials: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+		return fmt.Errorf("cannot create credentials directory: %w", err)
+	}
+	// Temporary files are created with 0600 permissions
+	tmp, err := ioutil.TempFile(filepath.Dir(p), filepath.Base(p)+".*")
+	if err != nil {
+		return fmt.Errorf("cannot create temporary credentials file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return fmt.Errorf("cannot write temporary credentials file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("cannot write temporary credentials file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), p); err != nil {
+		return fmt.Errorf("cannot replace credentials file %s: %w", p, err)
+	}
+	return nil
+}
+
+// SaveUserToken persists a reMarkable user token renewed for the
+// device stored at p, telling whether it did: tokens for devices
+// configured elsewhere are left alone.
+func SaveUserToken(p, deviceToken, userToken string) (bool, error) {
+	cr, err := Load(p)
+	if err != nil || cr.RemarkableDeviceToken != deviceToken {
+		return false, err
+	}
+	err = Save(p, func(cr *Credentials) {
+		cr.RemarkableUserToken = userToken
+	})
+	return err == nil, err
+}
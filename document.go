@@ -1,18 +1,25 @@
 package rm
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net/http"
 	"net/url"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
 	readability "github.com/go-shiori/go-readability"
 	"github.com/kennygrant/sanitize"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
 )
 
 type Document interface {
@@ -20,10 +27,26 @@ type Document interface {
 	Title() string
 	Content() string
 	Format() string
+	Metadata() Metadata
 }
 
+type Metadata struct {
+	Author    string
+	SiteName  string
+	SourceURL string
+	Published time.Time
+	Language  string
+	Excerpt   string
+}
+
+// untitled is the title of documents that have none.
+const untitled = "Untitled"
+
 type htmlDocument struct {
-	article readability.Article
+	article   readability.Article
+	source    *url.URL
+	language  string
+	published time.Time
 }
 
 func (h *htmlDocument) Slug() string {
@@ -32,7 +55,7 @@ func (h *htmlDocument) Slug() string {
 		source = h.article.SiteName
 	}
 	if len(source) <= 0 {
-		source = "Untitled"
+		source = untitled
 	}
 	return sanitize.Name(source)
 }
@@ -43,7 +66,7 @@ func (h *htmlDocument) Title() string {
 		source = h.article.SiteName
 	}
 	if len(source) <= 0 {
-		source = "Untitled"
+		source = untitled
 	}
 	return sanitize.HTML(source)
 }
@@ -56,38 +79,323 @@ func (h *htmlDocument) Content() string {
 	return h.article.Content
 }
 
+func (h *htmlDocument) Metadata() Metadata {
+	meta := Metadata{
+		Author:    strings.TrimSpace(sanitize.HTML(h.article.Byline)),
+		SiteName:  strings.TrimSpace(sanitize.HTML(h.article.SiteName)),
+		Published: h.published,
+		Language:  h.language,
+		Excerpt:   strings.TrimSpace(sanitize.HTML(h.article.Excerpt)),
+	}
+	if h.source != nil {
+		meta.SourceURL = h.source.String()
+	}
+	return meta
+}
+
+// NewHTMLDocument returns a Document made of already available HTML
+// content, e.g. the full text provided by a feed, that originates
+// from source, if known. Only the Author, SiteName, Published, Language and
+// Excerpt fields of meta are used.
+func NewHTMLDocument(source *url.URL, title, content string, meta Metadata) Document {
+	return &htmlDocument{
+		article: readability.Article{
+			Title:    title,
+			Byline:   meta.Author,
+			SiteName: meta.SiteName,
+			Excerpt:  meta.Excerpt,
+			Content:  content,
+		},
+		source:    source,
+		language:  meta.Language,
+		published: meta.Published,
+	}
+}
+
 func Retrieve(target *url.URL, timeout time.Duration) (Document, error) {
-	article, err := readability.FromURL(target.String(), timeout)
+	return RetrieveContext(context.Background(), target, timeout)
+}
+
+// RetrieveContext is Retrieve, aborting the download once ctx is done.
+func RetrieveContext(ctx context.Context, target *url.URL, timeout time.Duration) (Document, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
 	if err != nil {
 		return nil, err
 	}
-	return &htmlDocument{article: article}, nil
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch the page: %w", err)
+	}
+	defer resp.Body.Close()
+	if !strings.Contains(resp.Header.Get("Content-Type"), "text/html") {
+		return nil, fmt.Errorf("URL is not a HTML document")
+	}
+	page, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch the page: %w", err)
+	}
+	return ParseHTML(page, target)
 }
 
-func DocumentToEPUB(d Document, filename string, timeout time.Duration) error {
-	var meta struct {
-		Title string `json:"title"`
+// ParseHTML extracts the readable content of a HTML page, source
+// being the location it was read from.
+func ParseHTML(page []byte, source *url.URL) (Document, error) {
+	if !readability.IsReadable(bytes.NewReader(page)) {
+		return nil, fmt.Errorf("the page is not readable")
 	}
-	meta.Title = d.Title()
-	metafile, err := ioutil.TempFile("", "epub.*.json")
+	article, err := readability.FromReader(bytes.NewReader(page), source.String())
 	if err != nil {
-		return fmt.Errorf("cannot create epub metadata temporary file: %w", err)
+		return nil, err
+	}
+	doc := &htmlDocument{article: article, source: source}
+	if root, err := html.Parse(bytes.NewReader(page)); err == nil {
+		doc.language, doc.published = pageMetadata(root)
+	}
+	return doc, nil
+}
+
+var publishedMeta = map[string]bool{
+	"article:published_time":    true,
+	"og:published_time":         true,
+	"datepublished":             true,
+	"date":                      true,
+	"dc.date":                   true,
+	"dc.date.issued":            true,
+	"dcterms.created":           true,
+	"citation_publication_date": true,
+	"sailthru.date":             true,
+	"parsely-pub-date":          true,
+}
+
+var dateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04:05Z0700",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+	"2006/01/02",
+	time.RFC1123,
+	time.RFC1123Z,
+}
+
+func parseDate(value string) (time.Time, bool) {
+	value = strings.TrimSpace(value)
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func attribute(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if strings.EqualFold(a.Key, key) {
+			return strings.TrimSpace(a.Val)
+		}
+	}
+	return ""
+}
+
+// pageMetadata digs the language and publication date out of the
+// original page, since readability doesn't expose them.
+func pageMetadata(root *html.Node) (language string, published time.Time) {
+	var visit func(n *html.Node)
+	visit = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch n.DataAtom {
+			case atom.Html:
+				language = attribute(n, "lang")
+			case atom.Meta:
+				name := attribute(n, "property")
+				if len(name) <= 0 {
+					name = attribute(n, "name")
+				}
+				if len(name) <= 0 {
+					name = attribute(n, "itemprop")
+				}
+				if published.IsZero() && publishedMeta[strings.ToLower(name)] {
+					published, _ = parseDate(attribute(n, "content"))
+				}
+				if len(language) <= 0 && strings.EqualFold(attribute(n, "http-equiv"), "content-language") {
+					language = attribute(n, "content")
+				}
+			case atom.Time:
+				if published.IsZero() && hasAttribute(n, "pubdate") {
+					published, _ = parseDate(attribute(n, "datetime"))
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			visit(c)
+		}
+	}
+	visit(root)
+	return
+}
+
+func hasAttribute(n *html.Node, key string) bool {
+	for _, a := range n.Attr {
+		if strings.EqualFold(a.Key, key) {
+			return true
+		}
+	}
+	return false
+}
+
+type pandocIdentifier struct {
+	Scheme string `json:"scheme"`
+	Text   string `json:"text"`
+}
+
+type pandocMetadata struct {
+	Title       string             `json:"title"`
+	Author      []string           `json:"author,omitempty"`
+	Date        string             `json:"date,omitempty"`
+	Lang        string             `json:"lang,omitempty"`
+	Publisher   string             `json:"publisher,omitempty"`
+	Description string             `json:"description,omitempty"`
+	Identifier  []pandocIdentifier `json:"identifier,omitempty"`
+}
+
+func pandoc(ctx context.Context, d Document, filename string, timeout time.Duration, extra ...string) error {
+	m := d.Metadata()
+	meta := pandocMetadata{
+		Title:       d.Title(),
+		Lang:        m.Language,
+		Publisher:   m.SiteName,
+		Description: m.Excerpt,
+	}
+	if len(m.Author) > 0 {
+		meta.Author = []string{m.Author}
+	}
+	if !m.Published.IsZero() {
+		meta.Date = m.Published.Format("2006-01-02")
+	}
+	if len(m.SourceURL) > 0 {
+		meta.Identifier = []pandocIdentifier{{Scheme: "URI", Text: m.SourceURL}}
+	}
+	metafile, err := ioutil.TempFile("", "pandoc.*.json")
+	if err != nil {
+		return fmt.Errorf("cannot create pandoc metadata temporary file: %w", err)
 	}
 	defer os.Remove(metafile.Name())
 	metaContent, err := json.Marshal(meta)
 	if err != nil {
-		return fmt.Errorf("cannot marshal epub metadata: %s", err)
+		return fmt.Errorf("cannot marshal pandoc metadata: %s", err)
 	}
 	if err := ioutil.WriteFile(metafile.Name(), metaContent, 0644); err != nil {
-		return fmt.Errorf("cannot write epub metadata temporary file: %w", err)
+		return fmt.Errorf("cannot write pandoc metadata temporary file: %w", err)
+	}
+	args := []string{"-o", filename, "-f", d.Format(), "--metadata-file", metafile.Name()}
+	if resources := resourcesOf(d); len(resources) > 0 {
+		resourceDir, err := writeResources(resources)
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(resourceDir)
+		args = append(args, "--resource-path", resourceDir)
+	}
+	return command(ctx, coverOf(d)+d.Content(), timeout, "pandoc", append(args, extra...)...)
+}
+
+func DocumentToEPUB(d Document, filename string, timeout time.Duration) error {
+	return DocumentToEPUBContext(context.Background(), d, filename, timeout)
+}
+
+// DocumentToEPUBContext is DocumentToEPUB, killing pandoc once ctx
+// is done.
+func DocumentToEPUBContext(ctx context.Context, d Document, filename string, timeout time.Duration) error {
+	return pandoc(ctx, d, filename, timeout)
+}
+
+type PageSize struct {
+	Width  int
+	Height int
+	DPI    int
+}
+
+// RemarkablePage matches the reMarkable screen resolution.
+var RemarkablePage = PageSize{Width: 1404, Height: 1872, DPI: 226}
+
+func (p PageSize) inches(pixels int) string {
+	return strconv.FormatFloat(float64(pixels)/float64(p.DPI), 'f', 3, 64) + "in"
+}
+
+// DefaultPDFEngine is the LaTeX engine pandoc typesets PDF documents
+// with, unless WithPDFEngine says otherwise.
+const DefaultPDFEngine = "pdflatex"
+
+type pdfOptions struct {
+	Page   PageSize
+	Margin int
+	Engine string
+}
+
+type PDFOpt func(*pdfOptions)
+
+func WithPageSize(page PageSize) PDFOpt {
+	return func(o *pdfOptions) {
+		o.Page = page
+	}
+}
+
+// WithMargin sets the page margin, in pixels.
+func WithMargin(margin int) PDFOpt {
+	return func(o *pdfOptions) {
+		o.Margin = margin
+	}
+}
+
+func WithPDFEngine(engine string) PDFOpt {
+	return func(o *pdfOptions) {
+		o.Engine = engine
+	}
+}
+
+func DocumentToPDF(d Document, filename string, timeout time.Duration, opts ...PDFOpt) error {
+	return DocumentToPDFContext(context.Background(), d, filename, timeout, opts...)
+}
+
+// DocumentToPDFContext is DocumentToPDF, killing pandoc once ctx is
+// done.
+func DocumentToPDFContext(ctx context.Context, d Document, filename string, timeout time.Duration, opts ...PDFOpt) error {
+	o := &pdfOptions{
+		Page:   RemarkablePage,
+		Margin: 70,
+		Engine: DefaultPDFEngine,
+	}
+	for _, f := range opts {
+		f(o)
+	}
+	geometry := fmt.Sprintf("geometry:paperwidth=%s,paperheight=%s,margin=%s",
+		o.Page.inches(o.Page.Width), o.Page.inches(o.Page.Height), o.Page.inches(o.Margin))
+	return pandoc(ctx, d, filename, timeout, "--pdf-engine", o.Engine,
+		"-V", geometry, "-V", "fontsize=11pt", "-V", "colorlinks=true")
+}
+
+func writeResources(resources []Resource) (string, error) {
+	dir, err := ioutil.TempDir("", "resources")
+	if err != nil {
+		return "", fmt.Errorf("cannot create resources temporary directory: %w", err)
+	}
+	for _, r := range resources {
+		target := filepath.Join(dir, filepath.FromSlash(r.Name))
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			os.RemoveAll(dir)
+			return "", fmt.Errorf("cannot create resources temporary directory: %w", err)
+		}
+		if err := ioutil.WriteFile(target, r.Data, 0644); err != nil {
+			os.RemoveAll(dir)
+			return "", fmt.Errorf("cannot write resource %s: %w", r.Name, err)
+		}
 	}
-	err = command(d.Content(), timeout, "pandoc",
-		"-o", filename, "-f", d.Format(), "--metadata-file", metafile.Name())
-	return err
+	return dir, nil
 }
 
-func command(toStdin string, timeout time.Duration, exe string, args ...string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+func command(parent context.Context, toStdin string, timeout time.Duration, exe string, args ...string) error {
+	ctx, cancel := context.WithTimeout(parent, timeout)
 	defer cancel()
 	cmd := exec.CommandContext(ctx, exe, args...)
 	stdin, err := cmd.StdinPipe()
@@ -103,6 +411,9 @@ func command(toStdin string, timeout time.Duration, exe string, args ...string)
 	}
 	stdin.Close()
 	if err := cmd.Wait(); err != nil {
+		if parent.Err() != nil {
+			return fmt.Errorf("command aborted: %s: %w", cmd, parent.Err())
+		}
 		return err
 	}
 	if ctx.Err() == context.DeadlineExceeded {
@@ -0,0 +1,453 @@
+package rm
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+type epubFile struct {
+	ID         string
+	Href       string
+	MediaType  string
+	Properties string
+	Spine      bool
+	Data       []byte
+}
+
+type epubPackage struct {
+	Identifier  string
+	Title       string
+	Language    string
+	Modified    string
+	Author      string
+	Publisher   string
+	Date        string
+	Description string
+	Source      string
+	Files       []epubFile
+}
+
+const epubContainer = `<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="EPUB/package.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>
+`
+
+const epubStylesheet = `body { font-family: serif; line-height: 1.4; margin: 0 5%; }
+h1, h2, h3, h4, h5, h6 { font-family: sans-serif; line-height: 1.2; }
+h1.title { font-size: 1.8em; margin: 1em 0 0.5em 0; }
+p.byline, p.source { font-family: sans-serif; font-size: 0.85em; margin: 0.2em 0; }
+p.source { margin-bottom: 2em; word-wrap: break-word; }
+img { max-width: 100%; height: auto; }
+figure { margin: 1em 0; }
+figcaption { font-size: 0.9em; font-style: italic; }
+pre, code { font-family: monospace; font-size: 0.85em; }
+pre { white-space: pre-wrap; }
+blockquote { margin: 1em 2em; font-style: italic; }
+table { border-collapse: collapse; }
+td, th { border: 1px solid #888; padding: 0.2em 0.4em; }
+div.cover { font-family: sans-serif; text-align: center; margin-top: 20%; }
+h1.cover-title { font-size: 2.2em; margin: 1em 0; }
+p.cover-site { font-size: 1.1em; text-transform: uppercase; letter-spacing: 0.1em; }
+p.cover-author, p.cover-date { font-size: 1.1em; margin: 0.3em 0; }
+p.cover-qrcode { margin-top: 3em; }
+p.cover-qrcode img { width: 30%; }
+p.cover-url { font-size: 0.8em; word-wrap: break-word; }
+`
+
+var epubTemplates = template.Must(template.New("").Funcs(template.FuncMap{
+	"xml": xmlEscape,
+}).Parse(`
+{{- define "package.opf" -}}
+<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="uid" xml:lang="{{xml .Language}}">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:identifier id="uid">{{xml .Identifier}}</dc:identifier>
+    <dc:title>{{xml .Title}}</dc:title>
+    <dc:language>{{xml .Language}}</dc:language>
+{{- if .Author}}
+    <dc:creator id="creator">{{xml .Author}}</dc:creator>
+    <meta refines="#creator" property="role" scheme="marc:relators">aut</meta>
+{{- end}}
+{{- if .Publisher}}
+    <dc:publisher>{{xml .Publisher}}</dc:publisher>
+{{- end}}
+{{- if .Date}}
+    <dc:date>{{.Date}}</dc:date>
+{{- end}}
+{{- if .Description}}
+    <dc:description>{{xml .Description}}</dc:description>
+{{- end}}
+{{- if .Source}}
+    <dc:source>{{xml .Source}}</dc:source>
+{{- end}}
+    <meta property="dcterms:modified">{{.Modified}}</meta>
+  </metadata>
+  <manifest>
+    <item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+    <item id="ncx" href="toc.ncx" media-type="application/x-dtbncx+xml"/>
+{{- range .Files}}
+    <item id="{{xml .ID}}" href="{{xml .Href}}" media-type="{{xml .MediaType}}"{{if .Properties}} properties="{{xml .Properties}}"{{end}}/>
+{{- end}}
+  </manifest>
+  <spine toc="ncx">
+{{- range .Files}}{{if .Spine}}
+    <itemref idref="{{xml .ID}}"/>
+{{- end}}{{end}}
+  </spine>
+</package>
+{{end}}
+
+{{- define "nav.xhtml" -}}
+<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops" xml:lang="{{xml .Language}}" lang="{{xml .Language}}">
+<head>
+  <title>{{xml .Title}}</title>
+</head>
+<body>
+  <nav epub:type="toc" id="toc">
+    <ol>
+      <li><a href="content.xhtml">{{xml .Title}}</a></li>
+    </ol>
+  </nav>
+</body>
+</html>
+{{end}}
+
+{{- define "toc.ncx" -}}
+<?xml version="1.0" encoding="UTF-8"?>
+<ncx xmlns="http://www.daisy.org/z3986/2005/ncx/" version="2005-1">
+  <head>
+    <meta name="dtb:uid" content="{{xml .Identifier}}"/>
+  </head>
+  <docTitle><text>{{xml .Title}}</text></docTitle>
+{{- if .Author}}
+  <docAuthor><text>{{xml .Author}}</text></docAuthor>
+{{- end}}
+  <navMap>
+    <navPoint id="content" playOrder="1">
+      <navLabel><text>{{xml .Title}}</text></navLabel>
+      <content src="content.xhtml"/>
+    </navPoint>
+  </navMap>
+</ncx>
+{{end}}
+
+{{- define "cover.xhtml" -}}
+<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops" xml:lang="{{xml .Language}}" lang="{{xml .Language}}">
+<head>
+  <title>{{xml .Title}}</title>
+  <link rel="stylesheet" type="text/css" href="style.css"/>
+</head>
+<body epub:type="cover">
+{{.Body}}
+</body>
+</html>
+{{end}}
+
+{{- define "content.xhtml" -}}
+<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops" xml:lang="{{xml .Language}}" lang="{{xml .Language}}">
+<head>
+  <title>{{xml .Title}}</title>
+  <link rel="stylesheet" type="text/css" href="style.css"/>
+</head>
+<body>
+  <h1 class="title">{{xml .Title}}</h1>
+{{- if or .Author .Publisher .Date}}
+  <p class="byline">
+    {{- if .Author}}<span class="author">{{xml .Author}}</span>{{end}}
+    {{- if and .Author .Publisher}} &#8212; {{end}}
+    {{- if .Publisher}}<span class="publisher">{{xml .Publisher}}</span>{{end}}
+    {{- if .Date}} <span class="date">({{.Date}})</span>{{end -}}
+  </p>
+{{- end}}
+{{- if .Source}}
+  <p class="source"><a href="{{xml .Source}}">{{xml .Source}}</a></p>
+{{- end}}
+{{.Body}}
+</body>
+</html>
+{{end}}
+`))
+
+func xmlEscape(s string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+func epubIdentifier(d Document) string {
+	sum := sha1.Sum([]byte(d.Title() + "\x00" + d.Content()))
+	sum[6] = (sum[6] & 0x0f) | 0x50 // version 5
+	sum[8] = (sum[8] & 0x3f) | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("urn:uuid:%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+}
+
+func executeTemplate(name string, data interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := epubTemplates.ExecuteTemplate(&buf, name, data); err != nil {
+		return nil, fmt.Errorf("cannot render epub %s: %w", name, err)
+	}
+	return buf.Bytes(), nil
+}
+
+func newEPUBPackage(d Document) (*epubPackage, error) {
+	if d.Format() != "html" {
+		return nil, fmt.Errorf("unsupported document format %s", d.Format())
+	}
+	body, err := toXHTML(d.Content())
+	if err != nil {
+		return nil, fmt.Errorf("cannot convert document to xhtml: %w", err)
+	}
+	meta := d.Metadata()
+	pkg := &epubPackage{
+		Identifier:  epubIdentifier(d),
+		Title:       d.Title(),
+		Language:    meta.Language,
+		Modified:    time.Now().UTC().Format("2006-01-02T15:04:05Z"),
+		Author:      meta.Author,
+		Publisher:   meta.SiteName,
+		Description: meta.Excerpt,
+		Source:      meta.SourceURL,
+	}
+	if len(pkg.Language) <= 0 {
+		pkg.Language = "en"
+	}
+	if !meta.Published.IsZero() {
+		pkg.Date = meta.Published.Format("2006-01-02")
+	}
+	content, err := executeTemplate("content.xhtml", struct {
+		*epubPackage
+		Body string
+	}{pkg, body})
+	if err != nil {
+		return nil, err
+	}
+	pkg.Files = append(pkg.Files,
+		epubFile{ID: "style", Href: "style.css", MediaType: "text/css", Data: []byte(epubStylesheet)})
+	if cover := coverOf(d); len(cover) > 0 {
+		coverBody, err := toXHTML(cover)
+		if err != nil {
+			return nil, fmt.Errorf("cannot convert cover to xhtml: %w", err)
+		}
+		coverPage, err := executeTemplate("cover.xhtml", struct {
+			*epubPackage
+			Body string
+		}{pkg, coverBody})
+		if err != nil {
+			return nil, err
+		}
+		pkg.Files = append(pkg.Files,
+			epubFile{ID: "cover", Href: "cover.xhtml", MediaType: "application/xhtml+xml", Spine: true, Data: coverPage})
+	}
+	pkg.Files = append(pkg.Files,
+		epubFile{ID: "content", Href: "content.xhtml", MediaType: "application/xhtml+xml", Spine: true, Data: content})
+	for i, r := range resourcesOf(d) {
+		pkg.Files = append(pkg.Files, epubFile{
+			ID:        fmt.Sprintf("res%03d", i),
+			Href:      r.Name,
+			MediaType: r.MediaType,
+			Data:      r.Data,
+		})
+	}
+	return pkg, nil
+}
+
+// write archives the package into w, giving up once ctx is done.
+func (p *epubPackage) write(ctx context.Context, w io.Writer) error {
+	z := zip.NewWriter(w)
+	// The mimetype entry must come first and must not be compressed
+	mimetype, err := z.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(mimetype, "application/epub+zip"); err != nil {
+		return err
+	}
+	files := []epubFile{}
+	for _, name := range []string{"package.opf", "nav.xhtml", "toc.ncx"} {
+		data, err := executeTemplate(name, p)
+		if err != nil {
+			return err
+		}
+		files = append(files, epubFile{Href: name, Data: data})
+	}
+	files = append(files, p.Files...)
+	if err := writeZipFile(z, "META-INF/container.xml", []byte(epubContainer)); err != nil {
+		return err
+	}
+	for _, f := range files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := writeZipFile(z, "EPUB/"+f.Href, f.Data); err != nil {
+			return err
+		}
+	}
+	return z.Close()
+}
+
+func writeZipFile(z *zip.Writer, name string, data []byte) error {
+	w, err := z.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// DocumentToEPUBNative is a pure Go alternative to DocumentToEPUB
+// that doesn't need pandoc. Conversion is aborted after timeout,
+// unless it's zero.
+func DocumentToEPUBNative(d Document, filename string, timeout time.Duration) error {
+	return DocumentToEPUBNativeContext(context.Background(), d, filename, timeout)
+}
+
+// DocumentToEPUBNativeContext is DocumentToEPUBNative, aborting the
+// conversion once ctx is done as well.
+func DocumentToEPUBNativeContext(ctx context.Context, d Document, filename string, timeout time.Duration) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	pkg, err := newEPUBPackage(d)
+	if err != nil {
+		return err
+	}
+	out, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("cannot create epub file: %w", err)
+	}
+	if err := pkg.write(ctx, out); err != nil {
+		out.Close()
+		os.Remove(filename)
+		return fmt.Errorf("cannot write epub file: %w", err)
+	}
+	return out.Close()
+}
+
+var xmlName = regexp.MustCompile(`^[A-Za-z_][-A-Za-z0-9_.]*$`)
+
+var xhtmlDropped = map[atom.Atom]bool{
+	atom.Script:    true,
+	atom.Style:     true,
+	atom.Iframe:    true,
+	atom.Noembed:   true,
+	atom.Noframes:  true,
+	atom.Noscript:  true,
+	atom.Plaintext: true,
+	atom.Xmp:       true,
+	atom.Object:    true,
+	atom.Embed:     true,
+	atom.Form:      true,
+	atom.Link:      true,
+	atom.Meta:      true,
+}
+
+// xmlChars drops the runes not allowed in XML 1.0 documents, such
+// as most control characters, which HTML parsers let through.
+func xmlChars(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == '\t' || r == '\n' || r == '\r':
+			return r
+		case r >= 0x20 && r <= 0xd7ff, r >= 0xe000 && r <= 0xfffd, r >= 0x10000 && r <= 0x10ffff:
+			return r
+		}
+		return -1
+	}, s)
+}
+
+func sanitizeXHTML(n *html.Node) {
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		switch c.Type {
+		case html.CommentNode, html.DoctypeNode:
+			n.RemoveChild(c)
+		case html.TextNode:
+			c.Data = xmlChars(c.Data)
+		case html.ElementNode:
+			if xhtmlDropped[c.DataAtom] || !xmlName.MatchString(c.Data) {
+				n.RemoveChild(c)
+				break
+			}
+			attrs := c.Attr[:0]
+			for _, a := range c.Attr {
+				if strings.HasPrefix(a.Key, "on") || a.Key == "xmlns" || !xmlName.MatchString(a.Key) {
+					continue
+				}
+				if len(a.Namespace) > 0 && a.Namespace != "xlink" {
+					continue
+				}
+				a.Val = xmlChars(a.Val)
+				attrs = append(attrs, a)
+			}
+			c.Attr = attrs
+			if c.Data == "svg" && c.Namespace == "svg" {
+				c.Attr = append(c.Attr,
+					html.Attribute{Key: "xmlns", Val: "http://www.w3.org/2000/svg"},
+					html.Attribute{Key: "xmlns:xlink", Val: "http://www.w3.org/1999/xlink"})
+			}
+			if c.Data == "math" && c.Namespace == "math" {
+				c.Attr = append(c.Attr, html.Attribute{Key: "xmlns", Val: "http://www.w3.org/1998/Math/MathML"})
+			}
+			sanitizeXHTML(c)
+		}
+		c = next
+	}
+}
+
+func parseFragment(content string) (*html.Node, error) {
+	body := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+	nodes, err := html.ParseFragment(strings.NewReader(content), body)
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range nodes {
+		body.AppendChild(n)
+	}
+	return body, nil
+}
+
+func renderFragment(body *html.Node) (string, error) {
+	var buf bytes.Buffer
+	for c := body.FirstChild; c != nil; c = c.NextSibling {
+		if err := html.Render(&buf, c); err != nil {
+			return "", err
+		}
+	}
+	return buf.String(), nil
+}
+
+func toXHTML(content string) (string, error) {
+	body, err := parseFragment(content)
+	if err != nil {
+		return "", err
+	}
+	sanitizeXHTML(body)
+	return renderFragment(body)
+}
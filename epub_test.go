@@ -0,0 +1,60 @@
+package rm
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestEPUBContentIsXML(t *testing.T) {
+	source, _ := url.Parse("https://example.com/")
+	content := `<p title="a&#x1;b">Control&#x1; &#x8;characters<br>&amp; void elements &#x1F600;</p>` +
+		`<svg viewBox="0 0 1 1"><rect width="1" height="1"/></svg>`
+	d := NewHTMLDocument(source, "Title\x01", content, Metadata{})
+	pkg, err := newEPUBPackage(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range pkg.Files {
+		if !strings.HasSuffix(f.Href, ".xhtml") {
+			continue
+		}
+		dec := xml.NewDecoder(strings.NewReader(string(f.Data)))
+		text := ""
+		for {
+			tok, err := dec.Token()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("%s is not well-formed: %v", f.Href, err)
+			}
+			if data, ok := tok.(xml.CharData); ok {
+				text += string(data)
+			}
+		}
+		if f.Href == "content.xhtml" && !strings.Contains(text, "Control characters& void elements \U0001F600") {
+			t.Errorf("%s lost text content: %q", f.Href, text)
+		}
+	}
+}
+
+func TestEPUBWriteCancelled(t *testing.T) {
+	source, _ := url.Parse("https://example.com/")
+	pkg, err := newEPUBPackage(NewHTMLDocument(source, "Title", "<p>Content</p>", Metadata{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := pkg.write(ctx, &bytes.Buffer{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("got error %v, want %v", err, context.Canceled)
+	}
+	if err := pkg.write(context.Background(), &bytes.Buffer{}); err != nil {
+		t.Error(err)
+	}
+}
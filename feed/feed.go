@@ -0,0 +1,226 @@
+// Package feed reads RSS and Atom feeds and tails them as a source
+// of documents.
+package feed
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html/charset"
+)
+
+var ErrFormat = errors.New("unsupported feed format")
+
+// Feed is the common subset of RSS and Atom feeds.
+type Feed struct {
+	Title   string
+	Entries []Entry
+}
+
+type Entry struct {
+	// ID is the entry GUID, falling back to its link
+	ID         string
+	Link       string
+	Title      string
+	Author     string
+	Published  time.Time
+	Categories []string
+	// Content is the HTML content provided by the feed, if any
+	Content string
+}
+
+type rssItem struct {
+	Title       string   `xml:"title"`
+	Link        string   `xml:"link"`
+	GUID        string   `xml:"guid"`
+	PubDate     string   `xml:"pubDate"`
+	Date        string   `xml:"http://purl.org/dc/elements/1.1/ date"`
+	Author      string   `xml:"author"`
+	Creator     string   `xml:"http://purl.org/dc/elements/1.1/ creator"`
+	Categories  []string `xml:"category"`
+	Description string   `xml:"description"`
+	Encoded     string   `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
+}
+
+type rssChannel struct {
+	Title string    `xml:"title"`
+	Items []rssItem `xml:"item"`
+}
+
+type rss struct {
+	Channel rssChannel `xml:"channel"`
+}
+
+// rdf is RSS 1.0, where items are siblings of the channel
+type rdf struct {
+	Channel rssChannel `xml:"channel"`
+	Items   []rssItem  `xml:"item"`
+}
+
+type atomText struct {
+	Type  string `xml:"type,attr"`
+	Text  string `xml:",chardata"`
+	Inner string `xml:",innerxml"`
+}
+
+func (t *atomText) HTML() string {
+	switch t.Type {
+	case "xhtml":
+		return strings.TrimSpace(t.Inner)
+	case "html":
+		return strings.TrimSpace(t.Text)
+	}
+	if text := strings.TrimSpace(t.Text); len(text) > 0 {
+		return "<p>" + html.EscapeString(text) + "</p>"
+	}
+	return ""
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+}
+
+type atomEntry struct {
+	ID         string     `xml:"id"`
+	Title      atomText   `xml:"title"`
+	Links      []atomLink `xml:"link"`
+	Published  string     `xml:"published"`
+	Updated    string     `xml:"updated"`
+	Authors    []string   `xml:"author>name"`
+	Categories []struct {
+		Term string `xml:"term,attr"`
+	} `xml:"category"`
+	Summary atomText `xml:"summary"`
+	Content atomText `xml:"content"`
+}
+
+type atom struct {
+	Title   atomText    `xml:"title"`
+	Authors []string    `xml:"author>name"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+var dateLayouts = []string{
+	time.RFC1123Z,
+	time.RFC1123,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"Mon, 2 Jan 2006 15:04 -0700",
+	"2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 06 15:04:05 -0700",
+	time.RFC3339,
+	"2006-01-02T15:04:05Z07:00",
+	"2006-01-02",
+}
+
+func parseDate(values ...string) time.Time {
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		for _, layout := range dateLayouts {
+			if t, err := time.Parse(layout, value); err == nil {
+				return t
+			}
+		}
+	}
+	return time.Time{}
+}
+
+func first(values ...string) string {
+	for _, v := range values {
+		if v = strings.TrimSpace(v); len(v) > 0 {
+			return v
+		}
+	}
+	return ""
+}
+
+func fromRSS(title string, items []rssItem) *Feed {
+	f := &Feed{Title: strings.TrimSpace(title)}
+	for _, i := range items {
+		e := Entry{
+			Link:      strings.TrimSpace(i.Link),
+			Title:     strings.TrimSpace(i.Title),
+			Author:    first(i.Creator, i.Author),
+			Published: parseDate(i.PubDate, i.Date),
+			Content:   first(i.Encoded, i.Description),
+		}
+		e.ID = first(i.GUID, e.Link)
+		for _, c := range i.Categories {
+			if c = strings.TrimSpace(c); len(c) > 0 {
+				e.Categories = append(e.Categories, c)
+			}
+		}
+		f.Entries = append(f.Entries, e)
+	}
+	return f
+}
+
+func fromAtom(a *atom) *Feed {
+	f := &Feed{Title: strings.TrimSpace(a.Title.Text)}
+	for _, i := range a.Entries {
+		e := Entry{
+			Title:     strings.TrimSpace(i.Title.Text),
+			Published: parseDate(i.Published, i.Updated),
+			Content:   first(i.Content.HTML(), i.Summary.HTML()),
+		}
+		for _, l := range i.Links {
+			if l.Rel == "" || l.Rel == "alternate" {
+				e.Link = strings.TrimSpace(l.Href)
+				break
+			}
+		}
+		e.ID = first(i.ID, e.Link)
+		e.Author = first(append(i.Authors, a.Authors...)...)
+		for _, c := range i.Categories {
+			if term := strings.TrimSpace(c.Term); len(term) > 0 {
+				e.Categories = append(e.Categories, term)
+			}
+		}
+		f.Entries = append(f.Entries, e)
+	}
+	return f
+}
+
+// Parse reads an RSS 2.0, RSS 1.0 or Atom feed.
+func Parse(r io.Reader) (*Feed, error) {
+	d := xml.NewDecoder(r)
+	d.CharsetReader = charset.NewReaderLabel
+	d.Strict = false
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return nil, fmt.Errorf("cannot find feed root element: %w", ErrFormat)
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch strings.ToLower(start.Name.Local) {
+		case "rss":
+			var v rss
+			if err := d.DecodeElement(&v, &start); err != nil {
+				return nil, fmt.Errorf("invalid RSS feed: %w", err)
+			}
+			return fromRSS(v.Channel.Title, v.Channel.Items), nil
+		case "rdf":
+			var v rdf
+			if err := d.DecodeElement(&v, &start); err != nil {
+				return nil, fmt.Errorf("invalid RSS feed: %w", err)
+			}
+			return fromRSS(v.Channel.Title, v.Items), nil
+		case "feed":
+			var v atom
+			if err := d.DecodeElement(&v, &start); err != nil {
+				return nil, fmt.Errorf("invalid Atom feed: %w", err)
+			}
+			return fromAtom(&v), nil
+		}
+		return nil, fmt.Errorf("unexpected root element %s: %w", start.Name.Local, ErrFormat)
+	}
+}
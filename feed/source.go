@@ -0,0 +1,191 @@
+package feed
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/nazavode/rm"
+)
+
+const SourceName = "feed"
+
+// Source emits the entries of a single feed. Cursors hold the IDs
+// of the entries seen in the last fetch, so that only new entries
+// are emitted. Entries that failed are emitted again on the next
+// fetch, while still in the feed; rmd retries them on restart.
+type Source struct {
+	URL *url.URL
+	// FullContent uses the content provided by the feed, when
+	// available, instead of retrieving the entry page
+	FullContent bool
+	Timeout     time.Duration
+
+	mu           sync.Mutex
+	etag         string
+	lastModified string
+	failed       map[string]bool
+}
+
+func (s *Source) Name() string {
+	return SourceName + ":" + s.URL.String()
+}
+
+func (s *Source) fetch(ctx context.Context) (*Feed, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", s.URL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	// Entries to retry are needed even if the feed didn't change
+	if len(s.failed) <= 0 {
+		if len(s.etag) > 0 {
+			req.Header.Set("If-None-Match", s.etag)
+		}
+		if len(s.lastModified) > 0 {
+			req.Header.Set("If-Modified-Since", s.lastModified)
+		}
+	}
+	s.mu.Unlock()
+	client := &http.Client{Timeout: s.Timeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch feed %s: %w", s.URL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch feed %s: got response %d", s.URL, resp.StatusCode)
+	}
+	f, err := Parse(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("feed %s: %w", s.URL, err)
+	}
+	s.mu.Lock()
+	s.etag = resp.Header.Get("ETag")
+	s.lastModified = resp.Header.Get("Last-Modified")
+	s.mu.Unlock()
+	return f, nil
+}
+
+func (s *Source) item(f *Feed, e *Entry) (*rm.Item, error) {
+	u, err := s.URL.Parse(e.Link)
+	if err != nil || len(e.Link) <= 0 {
+		return nil, fmt.Errorf("feed %s: entry %q has no valid link", s.URL, e.ID)
+	}
+	item := &rm.Item{
+		ID:    e.ID,
+		URL:   u,
+		Title: e.Title,
+		Tags:  e.Categories,
+		Added: e.Published,
+		Ack:   s.ack(e.ID),
+	}
+	if s.FullContent && len(e.Content) > 0 {
+		item.Document = rm.NewHTMLDocument(u, e.Title, e.Content, rm.Metadata{
+			Author:    e.Author,
+			SiteName:  f.Title,
+			Published: e.Published,
+		})
+	}
+	return item, nil
+}
+
+// ack records the outcome of processing an entry, so that failed
+// ones are emitted anew.
+func (s *Source) ack(id string) func(context.Context, error) error {
+	return func(ctx context.Context, err error) error {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if err == nil {
+			delete(s.failed, id)
+			return nil
+		}
+		if s.failed == nil {
+			s.failed = make(map[string]bool)
+		}
+		s.failed[id] = true
+		return nil
+	}
+}
+
+// forget removes the entries that failed since the last call from
+// seen, so that they're emitted again.
+func (s *Source) forget(seen map[string]bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id := range s.failed {
+		delete(seen, id)
+	}
+	s.failed = nil
+}
+
+func (s *Source) Tail(ctx context.Context, cursor rm.Cursor, tick <-chan time.Time) <-chan interface{} {
+	seen := make(map[string]bool)
+	if len(cursor) > 0 {
+		ids := []string{}
+		if err := json.Unmarshal([]byte(cursor), &ids); err == nil {
+			for _, id := range ids {
+				seen[id] = true
+			}
+		}
+	}
+	out := make(chan interface{}, 1)
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-tick:
+				f, err := s.fetch(ctx)
+				if ctx.Err() != nil {
+					return
+				}
+				if err != nil {
+					out <- err
+					continue
+				}
+				if f == nil {
+					// Not modified
+					continue
+				}
+				s.forget(seen)
+				current := make(map[string]bool, len(f.Entries))
+				ids := make([]string, 0, len(f.Entries))
+				// Most feeds list newest entries first
+				for i := len(f.Entries) - 1; i >= 0; i-- {
+					e := &f.Entries[i]
+					if len(e.ID) <= 0 || current[e.ID] {
+						continue
+					}
+					current[e.ID] = true
+					ids = append(ids, e.ID)
+					if seen[e.ID] {
+						continue
+					}
+					item, err := s.item(f, e)
+					if err != nil {
+						out <- err
+						continue
+					}
+					out <- item
+				}
+				seen = current
+				data, _ := json.Marshal(ids)
+				out <- rm.Cursor(data)
+			}
+		}
+	}()
+	return out
+}
+
+func (s *Source) Resume(ctx context.Context, id string, u *url.URL) (*rm.Item, error) {
+	return &rm.Item{ID: id, URL: u, Ack: s.ack(id)}, nil
+}
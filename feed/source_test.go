@@ -0,0 +1,88 @@
+package feed
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nazavode/rm"
+)
+
+const testFeed = `<?xml version="1.0"?>
+<rss version="2.0"><channel><title>Feed</title>
+<item><guid>a</guid><link>https://example.com/a</link><title>A</title></item>
+<item><guid>b</guid><link>https://example.com/b</link><title>B</title></item>
+</channel></rss>`
+
+func TestTailRetriesFailed(t *testing.T) {
+	conditional := make(chan bool, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conditional <- len(r.Header.Get("If-None-Match")) > 0
+		if r.Header.Get("If-None-Match") == "v1" {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", "v1")
+		fmt.Fprint(w, testFeed)
+	}))
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL)
+	s := &Source{URL: u, Timeout: 5 * time.Second}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tick := make(chan time.Time)
+	out := s.Tail(ctx, "", tick)
+	poll := func() (items []*rm.Item, cursor rm.Cursor) {
+		tick <- time.Now()
+		if <-conditional {
+			t.Error("got a conditional request, want a full fetch")
+		}
+		for v := range out {
+			switch v := v.(type) {
+			case *rm.Item:
+				items = append(items, v)
+			case rm.Cursor:
+				return items, v
+			case error:
+				t.Fatal(v)
+			}
+		}
+		t.Fatal("tail ended without a cursor")
+		return nil, ""
+	}
+	items, cursor := poll()
+	if len(items) != 2 {
+		t.Fatalf("got %d items, want 2", len(items))
+	}
+	if !strings.Contains(string(cursor), `"a"`) || !strings.Contains(string(cursor), `"b"`) {
+		t.Errorf("got cursor %s, want both entries", cursor)
+	}
+	for _, item := range items {
+		var err error
+		if item.ID == "b" {
+			err = errors.New("failure")
+		}
+		if err := item.Acknowledge(err); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Failed entries are fetched again and emitted once more
+	items, _ = poll()
+	if len(items) != 1 || items[0].ID != "b" {
+		t.Fatalf("got items %v, want b only", items)
+	}
+	if err := items[0].Acknowledge(nil); err != nil {
+		t.Fatal(err)
+	}
+	// Then the feed is only fetched if modified
+	tick <- time.Now()
+	if !<-conditional {
+		t.Error("got a full fetch, want a conditional request")
+	}
+}
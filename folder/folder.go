@@ -0,0 +1,226 @@
+// Package folder watches a local directory as a source of
+// documents.
+package folder
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nazavode/rm"
+	"github.com/russross/blackfriday/v2"
+)
+
+const (
+	SourceName = "folder"
+	// DoneDir and FailedDir are the subdirectories processed files
+	// are moved to
+	DoneDir   = "done"
+	FailedDir = "failed"
+)
+
+// Source polls a directory for HTML, Markdown, PDF and EPUB files.
+// HTML and Markdown files are converted like any other document,
+// while PDF and EPUB ones are uploaded as they are. Once processed,
+// files are moved to the done or failed subdirectory.
+type Source struct {
+	Dir string
+	// MinAge leaves alone files modified more recently than that,
+	// since they might still be being written
+	MinAge time.Duration
+
+	mu sync.Mutex
+	// pending holds the files being processed
+	pending map[string]bool
+}
+
+func (s *Source) Name() string {
+	return SourceName + ":" + s.Dir
+}
+
+func supported(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".html", ".htm", ".md", ".markdown", ".pdf", ".epub":
+		return true
+	}
+	return false
+}
+
+// claim marks a file as being processed, returning false if it
+// already was.
+func (s *Source) claim(p string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pending == nil {
+		s.pending = make(map[string]bool)
+	}
+	if s.pending[p] {
+		return false
+	}
+	s.pending[p] = true
+	return true
+}
+
+func (s *Source) release(p string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.pending, p)
+}
+
+func (s *Source) scan() ([]*rm.Item, []error) {
+	infos, err := ioutil.ReadDir(s.Dir)
+	if err != nil {
+		return nil, []error{fmt.Errorf("cannot read folder %s: %w", s.Dir, err)}
+	}
+	items := []*rm.Item{}
+	errs := []error{}
+	for _, info := range infos {
+		name := info.Name()
+		if !info.Mode().IsRegular() || strings.HasPrefix(name, ".") || !supported(name) {
+			continue
+		}
+		if time.Since(info.ModTime()) < s.MinAge {
+			continue
+		}
+		p := filepath.Join(s.Dir, name)
+		if !s.claim(p) {
+			continue
+		}
+		id := name + "@" + strconv.FormatInt(info.ModTime().Unix(), 10)
+		item, err := s.item(p, id, info.ModTime())
+		if err != nil {
+			// Don't try again on every tick
+			item = &rm.Item{ID: id, Ack: s.ack(p)}
+			if err := item.Acknowledge(err); err != nil {
+				errs = append(errs, err)
+			}
+			errs = append(errs, err)
+			continue
+		}
+		items = append(items, item)
+	}
+	return items, errs
+}
+
+// Tail scans the folder on every tick. No cursors are emitted: the
+// files themselves are the state.
+func (s *Source) Tail(ctx context.Context, cursor rm.Cursor, tick <-chan time.Time) <-chan interface{} {
+	out := make(chan interface{}, 1)
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-tick:
+				items, errs := s.scan()
+				for _, err := range errs {
+					out <- err
+				}
+				for _, item := range items {
+					out <- item
+				}
+			}
+		}
+	}()
+	return out
+}
+
+func (s *Source) Resume(ctx context.Context, id string, u *url.URL) (*rm.Item, error) {
+	p := filepath.FromSlash(u.Path)
+	info, err := os.Stat(p)
+	if err != nil {
+		return nil, fmt.Errorf("cannot resume %s: %w", p, err)
+	}
+	if !s.claim(p) {
+		return nil, fmt.Errorf("%s is already being processed", p)
+	}
+	item, err := s.item(p, id, info.ModTime())
+	if err != nil {
+		s.release(p)
+		return nil, err
+	}
+	return item, nil
+}
+
+func (s *Source) item(p, id string, modified time.Time) (*rm.Item, error) {
+	u := &url.URL{Scheme: "file", Path: filepath.ToSlash(p)}
+	ext := strings.ToLower(filepath.Ext(p))
+	item := &rm.Item{
+		ID:    id,
+		URL:   u,
+		Title: strings.TrimSuffix(filepath.Base(p), filepath.Ext(p)),
+		Added: modified,
+		Ack:   s.ack(p),
+	}
+	switch ext {
+	case ".pdf", ".epub":
+		item.File = p
+		return item, nil
+	}
+	content, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	switch ext {
+	case ".md", ".markdown":
+		if title := markdownTitle(content); len(title) > 0 {
+			item.Title = title
+		}
+		item.Document = rm.NewHTMLDocument(u, item.Title, string(blackfriday.Run(content)), rm.Metadata{
+			Published: modified,
+		})
+	default:
+		doc, err := rm.ParseHTML(content, u)
+		if err != nil {
+			// Local files are often fragments, too short to
+			// look readable: take them as they are
+			doc = rm.NewHTMLDocument(u, item.Title, string(content), rm.Metadata{
+				Published: modified,
+			})
+		}
+		if title := doc.Title(); len(title) > 0 {
+			item.Title = title
+		}
+		item.Document = doc
+	}
+	return item, nil
+}
+
+// markdownTitle returns the first level one heading of a Markdown
+// document, if any.
+func markdownTitle(content []byte) string {
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "# ") {
+			return strings.TrimSpace(strings.Trim(line, "#"))
+		}
+	}
+	return ""
+}
+
+// ack moves the file to the done or failed subdirectory according
+// to the processing outcome.
+func (s *Source) ack(p string) func(context.Context, error) error {
+	return func(_ context.Context, err error) error {
+		defer s.release(p)
+		dir := filepath.Join(filepath.Dir(p), DoneDir)
+		if err != nil {
+			dir = filepath.Join(filepath.Dir(p), FailedDir)
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		return os.Rename(p, filepath.Join(dir, filepath.Base(p)))
+	}
+}
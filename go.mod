@@ -6,6 +6,10 @@ require (
 	github.com/go-shiori/go-readability v0.0.0-20201011032228-bdc871772408
 	github.com/juruen/rmapi v0.0.13
 	github.com/kennygrant/sanitize v1.2.4
+	github.com/russross/blackfriday/v2 v2.0.1
 	github.com/sirupsen/logrus v1.7.0
 	github.com/urfave/cli/v2 v2.3.0
+	golang.org/x/net v0.0.0-20201010224723-4f7140c49acb
+	gopkg.in/yaml.v2 v2.2.8
+	rsc.io/qr v0.2.0
 )
@@ -0,0 +1,243 @@
+package rm
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Resource is a file referenced by a document's content
+// through its relative Name, e.g. an embedded image.
+type Resource struct {
+	Name      string
+	MediaType string
+	Data      []byte
+}
+
+type resourceDocument interface {
+	Resources() []Resource
+}
+
+func resourcesOf(d Document) []Resource {
+	if r, ok := d.(resourceDocument); ok {
+		return r.Resources()
+	}
+	return nil
+}
+
+var imageExtensions = map[string]string{
+	"image/jpeg":    "jpg",
+	"image/png":     "png",
+	"image/gif":     "gif",
+	"image/webp":    "webp",
+	"image/svg+xml": "svg",
+}
+
+type imageOptions struct {
+	MaxCount int
+	MaxSize  int64
+	Timeout  time.Duration
+	Throttle func(ctx context.Context, u *url.URL, fetch func() error) error
+}
+
+type ImageOpt func(*imageOptions)
+
+func MaxImages(count int) ImageOpt {
+	return func(o *imageOptions) {
+		o.MaxCount = count
+	}
+}
+
+func MaxImageSize(size int64) ImageOpt {
+	return func(o *imageOptions) {
+		o.MaxSize = size
+	}
+}
+
+func ImageTimeout(timeout time.Duration) ImageOpt {
+	return func(o *imageOptions) {
+		o.Timeout = timeout
+	}
+}
+
+// ImageThrottle has every image download run through f, which calls
+// fetch when it sees fit, e.g. to bound requests to the same host.
+func ImageThrottle(f func(ctx context.Context, u *url.URL, fetch func() error) error) ImageOpt {
+	return func(o *imageOptions) {
+		o.Throttle = f
+	}
+}
+
+type imageDocument struct {
+	Document
+	content   string
+	resources []Resource
+}
+
+func (d *imageDocument) Content() string {
+	return d.content
+}
+
+func (d *imageDocument) Resources() []Resource {
+	return append(resourcesOf(d.Document), d.resources...)
+}
+
+func fetchImage(ctx context.Context, client *http.Client, target string, maxSize int64) (*Resource, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("got response %d", resp.StatusCode)
+	}
+	if resp.ContentLength > maxSize {
+		return nil, fmt.Errorf("image too large (%d > %d bytes)", resp.ContentLength, maxSize)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxSize {
+		return nil, fmt.Errorf("image too large (> %d bytes)", maxSize)
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if _, ok := imageExtensions[mediaType]; !ok {
+		mediaType, _, _ = mime.ParseMediaType(http.DetectContentType(data))
+	}
+	if _, ok := imageExtensions[mediaType]; !ok {
+		return nil, fmt.Errorf("unsupported image type %s", mediaType)
+	}
+	return &Resource{MediaType: mediaType, Data: data}, nil
+}
+
+func findImages(n *html.Node, images []*html.Node) []*html.Node {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode {
+			continue
+		}
+		if c.DataAtom == atom.Img {
+			images = append(images, c)
+		} else {
+			images = findImages(c, images)
+		}
+	}
+	return images
+}
+
+func removeSources(n *html.Node) {
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		if c.Type == html.ElementNode && c.DataAtom == atom.Source {
+			n.RemoveChild(c)
+		} else {
+			removeSources(c)
+		}
+		c = next
+	}
+}
+
+func setImageSource(img *html.Node, src string) {
+	attrs := img.Attr[:0]
+	for _, a := range img.Attr {
+		switch a.Key {
+		case "src", "srcset", "sizes":
+			continue
+		}
+		attrs = append(attrs, a)
+	}
+	img.Attr = append(attrs, html.Attribute{Key: "src", Val: src})
+}
+
+// EmbedImages downloads the images referenced by d, resolving relative
+// locations against base, and returns a Document whose content refers
+// to local copies of them. Images that cannot be retrieved within the
+// configured limits are removed from the content.
+func EmbedImages(d Document, base *url.URL, opts ...ImageOpt) (Document, error) {
+	return EmbedImagesContext(context.Background(), d, base, opts...)
+}
+
+// EmbedImagesContext is EmbedImages, aborting downloads once ctx is
+// done.
+func EmbedImagesContext(ctx context.Context, d Document, base *url.URL, opts ...ImageOpt) (Document, error) {
+	if d.Format() != "html" {
+		return d, nil
+	}
+	o := &imageOptions{
+		MaxCount: 50,
+		MaxSize:  5 << 20,
+		Timeout:  30 * time.Second,
+		Throttle: func(_ context.Context, _ *url.URL, fetch func() error) error {
+			return fetch()
+		},
+	}
+	for _, f := range opts {
+		f(o)
+	}
+	body, err := parseFragment(d.Content())
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse document content: %w", err)
+	}
+	removeSources(body)
+	client := &http.Client{Timeout: o.Timeout}
+	resources := []Resource{}
+	local := make(map[string]string)
+	for _, img := range findImages(body, nil) {
+		src := ""
+		for _, a := range img.Attr {
+			if a.Key == "src" {
+				src = strings.TrimSpace(a.Val)
+			}
+		}
+		if strings.HasPrefix(src, "data:") {
+			continue
+		}
+		target, err := base.Parse(src)
+		if len(src) <= 0 || err != nil || (target.Scheme != "http" && target.Scheme != "https") {
+			img.Parent.RemoveChild(img)
+			continue
+		}
+		name, ok := local[target.String()]
+		if !ok && len(resources) < o.MaxCount {
+			var res *Resource
+			err := o.Throttle(ctx, target, func() error {
+				var err error
+				res, err = fetchImage(ctx, client, target.String(), o.MaxSize)
+				return err
+			})
+			if err == nil {
+				res.Name = fmt.Sprintf("images/%03d.%s", len(resources), imageExtensions[res.MediaType])
+				resources = append(resources, *res)
+				name, ok = res.Name, true
+			}
+			local[target.String()] = name
+		}
+		if !ok || len(name) <= 0 {
+			img.Parent.RemoveChild(img)
+			continue
+		}
+		setImageSource(img, name)
+	}
+	// Don't pass off images missing due to cancellation as unavailable
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	content, err := renderFragment(body)
+	if err != nil {
+		return nil, fmt.Errorf("cannot render document content: %w", err)
+	}
+	return &imageDocument{Document: d, content: content, resources: resources}, nil
+}
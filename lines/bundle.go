@@ -0,0 +1,197 @@
+package lines
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Bundle is a document downloaded from reMarkable cloud and
+// unpacked into a local directory.
+type Bundle struct {
+	Dir       string
+	ID        string
+	Name      string
+	FileType  string
+	PageCount int
+	PageIDs   []string
+}
+
+type bundleContent struct {
+	FileType  string   `json:"fileType"`
+	PageCount int      `json:"pageCount"`
+	Pages     []string `json:"pages"`
+}
+
+type bundleMetadata struct {
+	VisibleName string `json:"visibleName"`
+}
+
+func OpenBundle(dir string) (*Bundle, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, "*.content"))
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) != 1 {
+		return nil, fmt.Errorf("%s: expected exactly one .content file, found %d", dir, len(matches))
+	}
+	data, err := ioutil.ReadFile(matches[0])
+	if err != nil {
+		return nil, err
+	}
+	var content bundleContent
+	if err := json.Unmarshal(data, &content); err != nil {
+		return nil, fmt.Errorf("cannot parse %s: %w", matches[0], err)
+	}
+	b := &Bundle{
+		Dir:       dir,
+		ID:        strings.TrimSuffix(filepath.Base(matches[0]), ".content"),
+		FileType:  content.FileType,
+		PageCount: content.PageCount,
+		PageIDs:   content.Pages,
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(dir, b.ID+".metadata")); err == nil {
+		var meta bundleMetadata
+		if err := json.Unmarshal(data, &meta); err == nil {
+			b.Name = meta.VisibleName
+		}
+	}
+	if b.PageCount < len(b.PageIDs) {
+		b.PageCount = len(b.PageIDs)
+	}
+	if b.PageCount <= 0 {
+		pages, _ := filepath.Glob(filepath.Join(dir, b.ID, "*.rm"))
+		b.PageCount = len(pages)
+	}
+	return b, nil
+}
+
+// Page returns the annotations of the i-th page, or nil if
+// the page has none.
+func (b *Bundle) Page(i int) (*Page, error) {
+	candidates := []string{strconv.Itoa(i)}
+	if i < len(b.PageIDs) {
+		candidates = append([]string{b.PageIDs[i]}, candidates...)
+	}
+	for _, name := range candidates {
+		p := filepath.Join(b.Dir, b.ID, name+".rm")
+		if _, err := os.Stat(p); err == nil {
+			return ReadFile(p)
+		}
+	}
+	return nil, nil
+}
+
+func (b *Bundle) Pages() ([]*Page, error) {
+	pages := make([]*Page, b.PageCount)
+	for i := range pages {
+		page, err := b.Page(i)
+		if err != nil {
+			return nil, err
+		}
+		pages[i] = page
+	}
+	return pages, nil
+}
+
+// Original returns the path of the original PDF document, if any.
+func (b *Bundle) Original() (string, bool) {
+	p := filepath.Join(b.Dir, b.ID+".pdf")
+	_, err := os.Stat(p)
+	return p, err == nil
+}
+
+// ExportSVG writes an SVG file for each annotated page into dir.
+func (b *Bundle) ExportSVG(dir string) error {
+	pages, err := b.Pages()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for i, page := range pages {
+		if page == nil {
+			continue
+		}
+		f, err := os.Create(filepath.Join(dir, fmt.Sprintf("page-%03d.svg", i+1)))
+		if err != nil {
+			return err
+		}
+		if err := page.SVG(f); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ErrNoOverlay is returned when annotations can't be overlaid onto
+// the original PDF, since qpdf isn't available, and were rendered on
+// blank pages instead.
+var ErrNoOverlay = errors.New("qpdf not found in $PATH, annotations rendered on blank pages")
+
+// ExportPDF writes the annotated document to filename. When the bundle
+// carries the original PDF, annotations are overlaid onto its pages
+// via qpdf; otherwise, or if qpdf is missing, they are rendered on
+// blank pages, ErrNoOverlay being returned in the latter case.
+func (b *Bundle) ExportPDF(filename string, timeout time.Duration) error {
+	pages, err := b.Pages()
+	if err != nil {
+		return err
+	}
+	original, ok := b.Original()
+	var noOverlay error
+	if _, err := exec.LookPath("qpdf"); ok && err != nil {
+		ok, noOverlay = false, ErrNoOverlay
+	}
+	target := filename
+	if ok {
+		tmp, err := ioutil.TempFile("", "annotations.*.pdf")
+		if err != nil {
+			return fmt.Errorf("cannot create annotations temporary file: %w", err)
+		}
+		tmp.Close()
+		defer os.Remove(tmp.Name())
+		target = tmp.Name()
+	}
+	out, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	if err := WritePDF(out, pages); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if !ok {
+		return noOverlay
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "qpdf", original, "--overlay", target, "--", filename)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		// Exit status 3 means success with warnings
+		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 3 {
+			return nil
+		}
+		if ctx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("command timed out (> %s): %s", timeout, cmd)
+		}
+		return fmt.Errorf("qpdf failed: %s: %w", strings.TrimSpace(string(output)), err)
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package lines
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExportPDFWithoutQPDF(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bundle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	v5, err := ioutil.ReadFile(filepath.Join("testdata", "v5.rm"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{
+		"doc.content":  []byte(`{"fileType": "pdf", "pageCount": 1, "pages": ["page"]}`),
+		"doc.pdf":      []byte("%PDF-1.4\n"),
+		"doc/page.rm":  v5,
+		"doc.metadata": []byte(`{"visibleName": "Document"}`),
+	}
+	for name, data := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b, err := OpenBundle(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", "")
+	out := filepath.Join(dir, "out.pdf")
+	if err := b.ExportPDF(out, 0); !errors.Is(err, ErrNoOverlay) {
+		t.Fatalf("got error %v, want %v", err, ErrNoOverlay)
+	}
+	data, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, []byte("%PDF-")) || !bytes.Contains(data, []byte("%%EOF")) {
+		t.Errorf("got no PDF with annotations on blank pages")
+	}
+}
@@ -0,0 +1,123 @@
+package lines
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Highlight is a passage of text marked with the highlighter
+// tool on EPUB or PDF documents.
+type Highlight struct {
+	Page   int    `json:"-"`
+	Text   string `json:"text"`
+	Start  int    `json:"start"`
+	Length int    `json:"length"`
+	Color  Color  `json:"color"`
+}
+
+type highlightsFile struct {
+	Highlights [][]Highlight `json:"highlights"`
+}
+
+// Highlights returns the highlighted passages of the document
+// sorted by page and position. Contiguous or overlapping passages,
+// as produced when highlighting across lines, are merged.
+func (b *Bundle) Highlights() ([]Highlight, error) {
+	files, err := filepath.Glob(filepath.Join(b.Dir, b.ID+".highlights", "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	pages := make(map[string]int, len(b.PageIDs))
+	for i, id := range b.PageIDs {
+		pages[id] = i
+	}
+	highlights := []Highlight{}
+	for _, name := range files {
+		id := strings.TrimSuffix(filepath.Base(name), ".json")
+		page, ok := pages[id]
+		if !ok {
+			if page, err = strconv.Atoi(id); err != nil {
+				return nil, fmt.Errorf("%s: unknown page %s", name, id)
+			}
+		}
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		var content highlightsFile
+		if err := json.Unmarshal(data, &content); err != nil {
+			return nil, fmt.Errorf("cannot parse %s: %w", name, err)
+		}
+		for _, group := range content.Highlights {
+			for _, h := range group {
+				h.Page = page
+				if len(strings.TrimSpace(h.Text)) > 0 {
+					highlights = append(highlights, h)
+				}
+			}
+		}
+	}
+	return mergeHighlights(highlights), nil
+}
+
+// mergeHighlights sorts highlights and joins those that touch or
+// overlap, by character offsets, so that shared text is kept once.
+func mergeHighlights(highlights []Highlight) []Highlight {
+	sort.SliceStable(highlights, func(i, j int) bool {
+		if highlights[i].Page != highlights[j].Page {
+			return highlights[i].Page < highlights[j].Page
+		}
+		return highlights[i].Start < highlights[j].Start
+	})
+	merged := highlights[:0]
+	for _, h := range highlights {
+		if n := len(merged); n > 0 {
+			last := &merged[n-1]
+			lastEnd := last.Start + last.Length
+			if last.Page == h.Page && h.Start <= lastEnd+1 {
+				if end := h.Start + h.Length; end > lastEnd {
+					text := []rune(h.Text)
+					switch overlap := lastEnd - h.Start; {
+					case overlap < 0:
+						// One character apart, e.g. a line break
+						last.Text = strings.TrimSpace(last.Text) + " " + strings.TrimSpace(h.Text)
+					case overlap < len(text):
+						last.Text += string(text[overlap:])
+					}
+					last.Length = end - last.Start
+				}
+				continue
+			}
+		}
+		merged = append(merged, h)
+	}
+	for i := range merged {
+		merged[i].Text = strings.TrimSpace(merged[i].Text)
+	}
+	return merged
+}
+
+// WriteMarkdown writes a note made of title, a link to the source
+// and the given highlights as block quotes.
+func WriteMarkdown(w io.Writer, title, source string, highlights []Highlight) error {
+	var md strings.Builder
+	fmt.Fprintf(&md, "# %s\n\n", title)
+	if len(source) > 0 {
+		fmt.Fprintf(&md, "Source: <%s>\n\n", source)
+	}
+	for _, h := range highlights {
+		lines := strings.Split(h.Text, "\n")
+		for i := range lines {
+			lines[i] = strings.TrimRight("> "+strings.TrimSpace(lines[i]), " ")
+		}
+		fmt.Fprintf(&md, "%s\n\n", strings.Join(lines, "\n"))
+	}
+	_, err := io.WriteString(w, md.String())
+	return err
+}
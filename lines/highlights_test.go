@@ -0,0 +1,83 @@
+package lines
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMergeHighlights(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		in   []Highlight
+		want []Highlight
+	}{
+		{
+			"apart",
+			[]Highlight{
+				{Page: 0, Text: "lorem", Start: 0, Length: 5},
+				{Page: 0, Text: "dolor", Start: 12, Length: 5},
+			},
+			[]Highlight{
+				{Page: 0, Text: "lorem", Start: 0, Length: 5},
+				{Page: 0, Text: "dolor", Start: 12, Length: 5},
+			},
+		},
+		{
+			"adjacent",
+			[]Highlight{
+				{Page: 0, Text: "lorem ", Start: 0, Length: 6},
+				{Page: 0, Text: "ipsum", Start: 6, Length: 5},
+			},
+			[]Highlight{{Page: 0, Text: "lorem ipsum", Start: 0, Length: 11}},
+		},
+		{
+			"across lines",
+			[]Highlight{
+				{Page: 0, Text: "ipsum", Start: 6, Length: 5},
+				{Page: 0, Text: "lorem", Start: 0, Length: 5},
+			},
+			[]Highlight{{Page: 0, Text: "lorem ipsum", Start: 0, Length: 11}},
+		},
+		{
+			"overlapping",
+			[]Highlight{
+				{Page: 0, Text: "lorem ipsum", Start: 0, Length: 11},
+				{Page: 0, Text: "ipsum dolor", Start: 6, Length: 11},
+			},
+			[]Highlight{{Page: 0, Text: "lorem ipsum dolor", Start: 0, Length: 17}},
+		},
+		{
+			"contained",
+			[]Highlight{
+				{Page: 0, Text: "lorem ipsum dolor", Start: 0, Length: 17},
+				{Page: 0, Text: "ipsum", Start: 6, Length: 5},
+			},
+			[]Highlight{{Page: 0, Text: "lorem ipsum dolor", Start: 0, Length: 17}},
+		},
+		{
+			"multibyte overlap",
+			[]Highlight{
+				{Page: 0, Text: "città è", Start: 0, Length: 7},
+				{Page: 0, Text: "è bella", Start: 6, Length: 7},
+			},
+			[]Highlight{{Page: 0, Text: "città è bella", Start: 0, Length: 13}},
+		},
+		{
+			"other page",
+			[]Highlight{
+				{Page: 1, Text: "ipsum", Start: 6, Length: 5},
+				{Page: 0, Text: "lorem", Start: 0, Length: 5},
+			},
+			[]Highlight{
+				{Page: 0, Text: "lorem", Start: 0, Length: 5},
+				{Page: 1, Text: "ipsum", Start: 6, Length: 5},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := mergeHighlights(tc.in); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,218 @@
+// Package lines decodes the reMarkable .lines format (.rm files), where
+// the tablet stores pen strokes, and renders pages to SVG and PDF.
+package lines
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Page dimensions in pixels, matching the tablet screen.
+const (
+	Width  = 1404
+	Height = 1872
+	DPI    = 226
+)
+
+const headerLen = 43
+
+var ErrFormat = errors.New("invalid .lines file")
+
+type Pen int32
+
+const (
+	Brush         Pen = 0
+	TiltPencil    Pen = 1
+	Ballpoint     Pen = 2
+	Marker        Pen = 3
+	Fineliner     Pen = 4
+	Highlighter   Pen = 5
+	Eraser        Pen = 6
+	SharpPencil   Pen = 7
+	EraseArea     Pen = 8
+	BrushV5       Pen = 12
+	SharpPencilV5 Pen = 13
+	TiltPencilV5  Pen = 14
+	BallpointV5   Pen = 15
+	MarkerV5      Pen = 16
+	FinelinerV5   Pen = 17
+	HighlighterV5 Pen = 18
+	CalligraphyV5 Pen = 21
+)
+
+func (p Pen) IsHighlighter() bool {
+	return p == Highlighter || p == HighlighterV5
+}
+
+func (p Pen) IsEraser() bool {
+	return p == Eraser || p == EraseArea
+}
+
+type Color int32
+
+const (
+	Black  Color = 0
+	Grey   Color = 1
+	White  Color = 2
+	Yellow Color = 3
+	Green  Color = 4
+	Pink   Color = 5
+	Blue   Color = 6
+	Red    Color = 7
+)
+
+// RGB returns the color components in the [0, 1] range.
+func (c Color) RGB() (r, g, b float64) {
+	switch c {
+	case Grey:
+		return 0.5, 0.5, 0.5
+	case White:
+		return 1, 1, 1
+	case Yellow:
+		return 1, 0.92, 0.23
+	case Green:
+		return 0.3, 0.69, 0.31
+	case Pink:
+		return 0.91, 0.12, 0.39
+	case Blue:
+		return 0.13, 0.59, 0.95
+	case Red:
+		return 0.9, 0.22, 0.21
+	}
+	return 0, 0, 0
+}
+
+type Point struct {
+	X         float32
+	Y         float32
+	Speed     float32
+	Direction float32
+	Width     float32
+	Pressure  float32
+}
+
+type Stroke struct {
+	Pen    Pen
+	Color  Color
+	Width  float32
+	Points []Point
+}
+
+type Layer struct {
+	Strokes []Stroke
+}
+
+type Page struct {
+	Version int
+	Layers  []Layer
+}
+
+type strokeHeaderV3 struct {
+	Pen    Pen
+	Color  Color
+	Unused int32
+	Width  float32
+}
+
+func Decode(r io.Reader) (*Page, error) {
+	var header [headerLen]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, fmt.Errorf("cannot read header: %w", ErrFormat)
+	}
+	page := &Page{}
+	switch strings.TrimRight(string(header[:]), " ") {
+	case "reMarkable .lines file, version=3":
+		page.Version = 3
+	case "reMarkable .lines file, version=5":
+		page.Version = 5
+	default:
+		return nil, fmt.Errorf("unsupported header %q: %w", strings.TrimSpace(string(header[:])), ErrFormat)
+	}
+	read := func(data interface{}) error {
+		if err := binary.Read(r, binary.LittleEndian, data); err != nil {
+			return fmt.Errorf("truncated file: %w", ErrFormat)
+		}
+		return nil
+	}
+	var count int32
+	if err := read(&count); err != nil {
+		return nil, err
+	}
+	page.Layers = make([]Layer, 0, clamp(count))
+	for l := int32(0); l < count; l++ {
+		var strokes int32
+		if err := read(&strokes); err != nil {
+			return nil, err
+		}
+		layer := Layer{Strokes: make([]Stroke, 0, clamp(strokes))}
+		for s := int32(0); s < strokes; s++ {
+			var header strokeHeaderV3
+			if err := read(&header); err != nil {
+				return nil, err
+			}
+			if page.Version >= 5 {
+				var unused float32
+				if err := read(&unused); err != nil {
+					return nil, err
+				}
+			}
+			var points int32
+			if err := read(&points); err != nil {
+				return nil, err
+			}
+			if points < 0 {
+				return nil, fmt.Errorf("negative point count: %w", ErrFormat)
+			}
+			stroke := Stroke{
+				Pen:    header.Pen,
+				Color:  header.Color,
+				Width:  header.Width,
+				Points: make([]Point, clamp(points)),
+			}
+			if int32(len(stroke.Points)) < points {
+				stroke.Points = make([]Point, 0, clamp(points))
+				for p := int32(0); p < points; p++ {
+					var point Point
+					if err := read(&point); err != nil {
+						return nil, err
+					}
+					stroke.Points = append(stroke.Points, point)
+				}
+			} else if err := read(stroke.Points); err != nil {
+				return nil, err
+			}
+			layer.Strokes = append(layer.Strokes, stroke)
+		}
+		page.Layers = append(page.Layers, layer)
+	}
+	return page, nil
+}
+
+// clamp bounds preallocations driven by untrusted counts.
+func clamp(n int32) int32 {
+	if n < 0 {
+		return 0
+	}
+	if n > 1<<16 {
+		return 1 << 16
+	}
+	return n
+}
+
+func ReadFile(name string) (*Page, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	page, err := Decode(bufio.NewReader(f))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return page, nil
+}
@@ -0,0 +1,110 @@
+package lines
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestDecodeV3(t *testing.T) {
+	page, err := ReadFile("testdata/v3.rm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Version != 3 {
+		t.Errorf("got version %d, want 3", page.Version)
+	}
+	if len(page.Layers) != 2 {
+		t.Fatalf("got %d layers, want 2", len(page.Layers))
+	}
+	if n := len(page.Layers[0].Strokes); n != 1 {
+		t.Fatalf("got %d strokes in layer 1, want 1", n)
+	}
+	stroke := page.Layers[0].Strokes[0]
+	if stroke.Pen != Fineliner || stroke.Color != Black || stroke.Width != 2 {
+		t.Errorf("got stroke %v/%v/%v, want fineliner/black/2", stroke.Pen, stroke.Color, stroke.Width)
+	}
+	want := []Point{
+		{X: 100, Y: 200, Speed: 0.1, Direction: 0.2, Width: 2.5, Pressure: 0.5},
+		{X: 110, Y: 220, Speed: 0.1, Direction: 0.2, Width: 2.5, Pressure: 0.6},
+	}
+	if len(stroke.Points) != len(want) {
+		t.Fatalf("got %d points, want %d", len(stroke.Points), len(want))
+	}
+	for i := range want {
+		if stroke.Points[i] != want[i] {
+			t.Errorf("point %d: got %+v, want %+v", i, stroke.Points[i], want[i])
+		}
+	}
+	strokes := page.Layers[1].Strokes
+	if len(strokes) != 2 || !strokes[0].Pen.IsHighlighter() || !strokes[1].Pen.IsEraser() {
+		t.Errorf("got layer 2 strokes %+v, want a highlighter and an eraser", strokes)
+	}
+	if n := len(page.Strokes()); n != 3 {
+		t.Errorf("got %d strokes overall, want 3", n)
+	}
+}
+
+func TestDecodeV5(t *testing.T) {
+	page, err := ReadFile("testdata/v5.rm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Version != 5 {
+		t.Errorf("got version %d, want 5", page.Version)
+	}
+	if len(page.Layers) != 1 || len(page.Layers[0].Strokes) != 1 {
+		t.Fatalf("got %+v, want a single stroke", page.Layers)
+	}
+	stroke := page.Layers[0].Strokes[0]
+	if stroke.Pen != FinelinerV5 || stroke.Color != Blue {
+		t.Errorf("got stroke %v/%v, want fineliner/blue", stroke.Pen, stroke.Color)
+	}
+	if len(stroke.Points) != 3 || stroke.Points[2].X != 50 || stroke.Points[2].Y != 60 {
+		t.Errorf("got points %+v", stroke.Points)
+	}
+}
+
+func TestDecodeHeader(t *testing.T) {
+	for _, header := range []string{
+		"",
+		"reMarkable .lines file",
+		"reMarkable .lines file, version=6          ",
+		"reMarkable .lines file, version=4          ",
+		strings.Repeat("x", 64),
+	} {
+		_, err := Decode(strings.NewReader(header))
+		if !errors.Is(err, ErrFormat) {
+			t.Errorf("header %q: got error %v, want ErrFormat", header, err)
+		}
+	}
+}
+
+func TestDecodeTruncated(t *testing.T) {
+	for _, name := range []string{"testdata/v3.rm", "testdata/v5.rm"} {
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for n := 0; n < len(data); n++ {
+			if _, err := Decode(bytes.NewReader(data[:n])); !errors.Is(err, ErrFormat) {
+				t.Errorf("%s truncated at %d: got error %v, want ErrFormat", name, n, err)
+			}
+		}
+	}
+}
+
+func TestDecodeHugeCounts(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/v5.rm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Point count of the only stroke, claiming way more than available
+	data = append([]byte{}, data...)
+	copy(data[headerLen+4+4+20:], []byte{0xff, 0xff, 0xff, 0x7f})
+	if _, err := Decode(bytes.NewReader(data)); !errors.Is(err, ErrFormat) {
+		t.Errorf("got error %v, want ErrFormat", err)
+	}
+}
@@ -0,0 +1,123 @@
+package lines
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+)
+
+const highlighterState = "GSh"
+
+func pdfPageSize() (float64, float64) {
+	return float64(Width) * 72 / DPI, float64(Height) * 72 / DPI
+}
+
+func (p *Page) pdfContent() []byte {
+	var buf bytes.Buffer
+	_, h := pdfPageSize()
+	scale := 72.0 / DPI
+	fmt.Fprintf(&buf, "q %.5f 0 0 %.5f 0 %.3f cm 1 J 1 j\n", scale, -scale, h)
+	if p != nil {
+		for _, stroke := range p.Strokes() {
+			st, ok := stroke.style()
+			if !ok {
+				continue
+			}
+			buf.WriteString("q ")
+			if st.Opacity < 1 {
+				fmt.Fprintf(&buf, "/%s gs ", highlighterState)
+			}
+			fmt.Fprintf(&buf, "%.3f %.3f %.3f RG %.3f w\n", st.R, st.G, st.B, st.Width)
+			for i, point := range stroke.Points {
+				op := "l"
+				if i == 0 {
+					op = "m"
+				}
+				fmt.Fprintf(&buf, "%.2f %.2f %s\n", point.X, point.Y, op)
+			}
+			if len(stroke.Points) == 1 {
+				fmt.Fprintf(&buf, "%.2f %.2f l\n", stroke.Points[0].X, stroke.Points[0].Y)
+			}
+			buf.WriteString("S Q\n")
+		}
+	}
+	buf.WriteString("Q\n")
+	return buf.Bytes()
+}
+
+type pdfWriter struct {
+	w       *bufio.Writer
+	offset  int
+	offsets []int
+	err     error
+}
+
+func (pw *pdfWriter) printf(format string, args ...interface{}) {
+	if pw.err != nil {
+		return
+	}
+	n, err := fmt.Fprintf(pw.w, format, args...)
+	pw.offset += n
+	pw.err = err
+}
+
+func (pw *pdfWriter) write(data []byte) {
+	if pw.err != nil {
+		return
+	}
+	n, err := pw.w.Write(data)
+	pw.offset += n
+	pw.err = err
+}
+
+func (pw *pdfWriter) object(id int, body string) {
+	pw.offsets[id-1] = pw.offset
+	pw.printf("%d 0 obj\n%s\nendobj\n", id, body)
+}
+
+func (pw *pdfWriter) stream(id int, data []byte) {
+	var compressed bytes.Buffer
+	z := zlib.NewWriter(&compressed)
+	z.Write(data)
+	z.Close()
+	pw.offsets[id-1] = pw.offset
+	pw.printf("%d 0 obj\n<< /Length %d /Filter /FlateDecode >>\nstream\n", id, compressed.Len())
+	pw.write(compressed.Bytes())
+	pw.printf("\nendstream\nendobj\n")
+}
+
+// WritePDF renders pages as a PDF document, one tablet-sized page
+// each; nil pages are rendered blank.
+func WritePDF(w io.Writer, pages []*Page) error {
+	// Objects: 1 catalog, 2 page tree, 3 graphics state,
+	// then a page and its content stream for each page.
+	pw := &pdfWriter{w: bufio.NewWriter(w), offsets: make([]int, 3+2*len(pages))}
+	width, height := pdfPageSize()
+	pw.printf("%%PDF-1.4\n%%\xe2\xe3\xcf\xd3\n")
+	pw.object(1, "<< /Type /Catalog /Pages 2 0 R >>")
+	var kids bytes.Buffer
+	for i := range pages {
+		fmt.Fprintf(&kids, "%d 0 R ", 4+2*i)
+	}
+	pw.object(2, fmt.Sprintf("<< /Type /Pages /Kids [ %s] /Count %d >>", kids.String(), len(pages)))
+	pw.object(3, "<< /Type /ExtGState /CA 0.35 /BM /Multiply >>")
+	for i, page := range pages {
+		pageID := 4 + 2*i
+		pw.object(pageID, fmt.Sprintf(
+			"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.3f %.3f] /Resources << /ExtGState << /%s 3 0 R >> >> /Contents %d 0 R >>",
+			width, height, highlighterState, pageID+1))
+		pw.stream(pageID+1, page.pdfContent())
+	}
+	xref := pw.offset
+	pw.printf("xref\n0 %d\n0000000000 65535 f \n", len(pw.offsets)+1)
+	for _, offset := range pw.offsets {
+		pw.printf("%010d 00000 n \n", offset)
+	}
+	pw.printf("trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(pw.offsets)+1, xref)
+	if pw.err != nil {
+		return pw.err
+	}
+	return pw.w.Flush()
+}
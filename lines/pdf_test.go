@@ -0,0 +1,68 @@
+package lines
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestWritePDF(t *testing.T) {
+	page, err := ReadFile("testdata/v3.rm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := WritePDF(&buf, []*Page{page, nil}); err != nil {
+		t.Fatal(err)
+	}
+	pdf := buf.Bytes()
+	if !bytes.HasPrefix(pdf, []byte("%PDF-1.4\n")) || !bytes.HasSuffix(pdf, []byte("%%EOF\n")) {
+		t.Fatal("missing PDF header or trailer")
+	}
+	if !bytes.Contains(pdf, []byte("/Count 2")) {
+		t.Error("page tree doesn't count 2 pages")
+	}
+	// Every cross reference entry points to its object
+	m := regexp.MustCompile(`startxref\n(\d+)\n`).FindSubmatch(pdf)
+	if m == nil {
+		t.Fatal("missing startxref")
+	}
+	xref, _ := strconv.Atoi(string(m[1]))
+	if !bytes.HasPrefix(pdf[xref:], []byte("xref\n0 8\n")) {
+		t.Fatalf("startxref doesn't point to an 8 entries table")
+	}
+	entries := strings.Split(string(pdf[xref:]), "\n")[3:10]
+	for i, entry := range entries {
+		offset, _ := strconv.Atoi(entry[:10])
+		if obj := fmt.Sprintf("%d 0 obj\n", i+1); !bytes.HasPrefix(pdf[offset:], []byte(obj)) {
+			t.Errorf("xref entry %d doesn't point to its object", i+1)
+		}
+	}
+	// The first page draws the fineliner and highlighter strokes,
+	// the eraser is left out
+	streams := regexp.MustCompile(`(?s)stream\n(.*?)\nendstream`).FindAllSubmatch(pdf, -1)
+	if len(streams) != 2 {
+		t.Fatalf("got %d content streams, want 2", len(streams))
+	}
+	z, err := zlib.NewReader(bytes.NewReader(streams[0][1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadAll(z)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, op := range []string{"100.00 200.00 m", "110.00 220.00 l", "/GSh gs", "300.00 400.00 m"} {
+		if !bytes.Contains(content, []byte(op)) {
+			t.Errorf("content stream misses %q", op)
+		}
+	}
+	if n := bytes.Count(content, []byte("S Q")); n != 2 {
+		t.Errorf("got %d strokes drawn, want 2", n)
+	}
+}
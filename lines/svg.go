@@ -0,0 +1,78 @@
+package lines
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+type style struct {
+	R, G, B float64
+	Width   float64
+	Opacity float64
+}
+
+// style returns how a stroke should be drawn; erasers are not drawn at all.
+func (s *Stroke) style() (style, bool) {
+	if s.Pen.IsEraser() || len(s.Points) <= 0 {
+		return style{}, false
+	}
+	st := style{Opacity: 1}
+	st.R, st.G, st.B = s.Color.RGB()
+	var width float64
+	for _, p := range s.Points {
+		width += float64(p.Width)
+	}
+	st.Width = width / float64(len(s.Points))
+	if st.Width <= 0 {
+		st.Width = float64(s.Width)
+	}
+	if s.Pen.IsHighlighter() {
+		st.Opacity = 0.35
+		if s.Color == Black || s.Color == Grey || s.Color == White {
+			st.R, st.G, st.B = Yellow.RGB()
+		}
+	}
+	return st, true
+}
+
+func (p *Page) Strokes() []Stroke {
+	strokes := []Stroke{}
+	for _, l := range p.Layers {
+		strokes = append(strokes, l.Strokes...)
+	}
+	return strokes
+}
+
+func (p *Page) SVG(w io.Writer) error {
+	out := bufio.NewWriter(w)
+	fmt.Fprintf(out, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n")
+	fmt.Fprintf(out, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%d\" height=\"%d\" viewBox=\"0 0 %d %d\">\n",
+		Width, Height, Width, Height)
+	for l, layer := range p.Layers {
+		fmt.Fprintf(out, "<g id=\"layer%d\" fill=\"none\" stroke-linecap=\"round\" stroke-linejoin=\"round\">\n", l+1)
+		for i := range layer.Strokes {
+			stroke := &layer.Strokes[i]
+			st, ok := stroke.style()
+			if !ok {
+				continue
+			}
+			fmt.Fprintf(out, "<polyline stroke=\"rgb(%d,%d,%d)\" stroke-width=\"%.3f\"",
+				int(st.R*255), int(st.G*255), int(st.B*255), st.Width)
+			if st.Opacity < 1 {
+				fmt.Fprintf(out, " stroke-opacity=\"%.2f\"", st.Opacity)
+			}
+			out.WriteString(" points=\"")
+			for j, point := range stroke.Points {
+				if j > 0 {
+					out.WriteByte(' ')
+				}
+				fmt.Fprintf(out, "%.2f,%.2f", point.X, point.Y)
+			}
+			out.WriteString("\"/>\n")
+		}
+		out.WriteString("</g>\n")
+	}
+	out.WriteString("</svg>\n")
+	return out.Flush()
+}
@@ -0,0 +1,93 @@
+package rm
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"net/url"
+	"strings"
+	"text/template"
+
+	"github.com/kennygrant/sanitize"
+)
+
+const DefaultNameTemplate = "{{.Title}}"
+
+const maxNameLength = 200
+
+// NameData holds the fields available to naming templates.
+type NameData struct {
+	Title  string
+	Site   string
+	Author string
+	// Date is the publication date as YYYY-MM-DD, if known
+	Date   string
+	Domain string
+	ID     string
+	// Hash is a short digest of the source name and ID, or of the
+	// source URL if ID is empty, that stays the same across runs
+	Hash string
+}
+
+// Namer names documents after a text/template.
+type Namer struct {
+	tmpl *template.Template
+}
+
+func NewNamer(text string) (*Namer, error) {
+	tmpl, err := template.New("name").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid name template: %w", err)
+	}
+	// Catch references to unknown fields early
+	if err := tmpl.Execute(&strings.Builder{}, NameData{}); err != nil {
+		return nil, fmt.Errorf("invalid name template: %w", err)
+	}
+	return &Namer{tmpl}, nil
+}
+
+// Name returns the visible name of d along with a file basename,
+// suitable for the local copy, that is disambiguated by the hash
+// of the item id within source; items from different sources may
+// share IDs.
+func (n *Namer) Name(d Document, source, id string) (name, basename string, err error) {
+	meta := d.Metadata()
+	key := source + "\x00" + id
+	if len(id) <= 0 {
+		key = meta.SourceURL
+	}
+	sum := sha1.Sum([]byte(key))
+	data := NameData{
+		Title:  html.UnescapeString(d.Title()),
+		Site:   meta.SiteName,
+		Author: meta.Author,
+		ID:     id,
+		Hash:   hex.EncodeToString(sum[:])[:8],
+	}
+	if data.Title == untitled {
+		// Keep untitled documents apart on the tablet
+		data.Title = untitled + " " + data.Hash
+	}
+	if !meta.Published.IsZero() {
+		data.Date = meta.Published.Format("2006-01-02")
+	}
+	if u, err := url.Parse(meta.SourceURL); err == nil {
+		data.Domain = strings.TrimPrefix(u.Hostname(), "www.")
+	}
+	var out strings.Builder
+	if err := n.tmpl.Execute(&out, data); err != nil {
+		return "", "", fmt.Errorf("cannot execute name template: %w", err)
+	}
+	// Slashes would be taken as path separators upstream
+	name = strings.ReplaceAll(out.String(), "/", "-")
+	name = strings.Trim(strings.Join(strings.Fields(name), " "), " -_")
+	if len(name) <= 0 {
+		name = untitled + " " + data.Hash
+	}
+	if runes := []rune(name); len(runes) > maxNameLength {
+		name = strings.TrimSpace(string(runes[:maxNameLength]))
+	}
+	basename = sanitize.Name(name) + "-" + data.Hash
+	return name, basename, nil
+}
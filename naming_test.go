@@ -0,0 +1,44 @@
+package rm
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestNameUntitled(t *testing.T) {
+	n, err := NewNamer(DefaultNameTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source, _ := url.Parse("https://example.com/")
+	names := map[string]string{}
+	for _, id := range []string{"1", "2"} {
+		d := NewHTMLDocument(source, "", "<p>Content</p>", Metadata{})
+		name, _, err := n.Name(d, "pocket", id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if other, ok := names[name]; ok {
+			t.Errorf("items %s and %s are both named %q", other, id, name)
+		}
+		names[name] = id
+	}
+	d := NewHTMLDocument(source, "A title", "<p>Content</p>", Metadata{})
+	if name, _, _ := n.Name(d, "pocket", "3"); name != "A title" {
+		t.Errorf("got name %q, want %q", name, "A title")
+	}
+}
+
+func TestNameNoSource(t *testing.T) {
+	n, err := NewNamer("{{.Domain}} {{.Title}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := NewHTMLDocument(nil, "A title", "<p>Content</p>", Metadata{})
+	if url := d.Metadata().SourceURL; len(url) > 0 {
+		t.Errorf("got source URL %q, want none", url)
+	}
+	if name, _, err := n.Name(d, "feed", "1"); err != nil || name != "A title" {
+		t.Errorf("got name %q and error %v, want %q", name, err, "A title")
+	}
+}
@@ -2,11 +2,15 @@ package pocket
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 )
 
+// Host is the Pocket API endpoint.
+var Host = "https://getpocket.com"
+
 type Auth struct {
 	ConsumerKey string `json:"consumer_key"`
 	AccessToken string `json:"access_token"`
@@ -26,12 +30,12 @@ func doJSON(req *http.Request, res interface{}) error {
 	return json.NewDecoder(resp.Body).Decode(res)
 }
 
-func postJSON(action string, data, res interface{}) error {
+func postJSON(ctx context.Context, action string, data, res interface{}) error {
 	body, err := json.Marshal(data)
 	if err != nil {
 		return err
 	}
-	req, err := http.NewRequest("POST", "https://getpocket.com"+action, bytes.NewReader(body))
+	req, err := http.NewRequestWithContext(ctx, "POST", Host+action, bytes.NewReader(body))
 	if err != nil {
 		return err
 	}
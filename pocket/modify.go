@@ -0,0 +1,83 @@
+package pocket
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type Action struct {
+	Action string `json:"action"`
+	ItemID int    `json:"item_id,string"`
+	Tags   string `json:"tags,omitempty"`
+	Time   int64  `json:"time,omitempty"`
+}
+
+func newAction(action string, itemID int, tags ...string) Action {
+	return Action{
+		Action: action,
+		ItemID: itemID,
+		Tags:   strings.Join(tags, ","),
+		Time:   time.Now().Unix(),
+	}
+}
+
+func Archive(itemID int) Action {
+	return newAction("archive", itemID)
+}
+
+func Readd(itemID int) Action {
+	return newAction("readd", itemID)
+}
+
+func Favorite(itemID int) Action {
+	return newAction("favorite", itemID)
+}
+
+func TagsAdd(itemID int, tags ...string) Action {
+	return newAction("tags_add", itemID, tags...)
+}
+
+func TagsRemove(itemID int, tags ...string) Action {
+	return newAction("tags_remove", itemID, tags...)
+}
+
+func TagsReplace(itemID int, tags ...string) Action {
+	return newAction("tags_replace", itemID, tags...)
+}
+
+type modifyPayload struct {
+	*Auth
+	Actions []Action `json:"actions"`
+}
+
+type modifyResult struct {
+	Status        int    `json:"status"`
+	ActionResults []bool `json:"action_results"`
+}
+
+func (a *Auth) Modify(actions ...Action) error {
+	return a.ModifyContext(context.Background(), actions...)
+}
+
+// ModifyContext is Modify, aborting the request once ctx is done.
+func (a *Auth) ModifyContext(ctx context.Context, actions ...Action) error {
+	if len(actions) <= 0 {
+		return nil
+	}
+	args := modifyPayload{a, actions}
+	res := &modifyResult{}
+	if err := postJSON(ctx, "/v3/send", args, res); err != nil {
+		return err
+	}
+	if res.Status != 1 {
+		return fmt.Errorf("modify request failed with status %d", res.Status)
+	}
+	for i, ok := range res.ActionResults {
+		if !ok && i < len(actions) {
+			return fmt.Errorf("action %s failed on item %d", actions[i].Action, actions[i].ItemID)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package pocket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// ErrTimeout is returned when the user doesn't complete the
+// authorization in time.
+var ErrTimeout = errors.New("authorization timed out")
+
+type requestTokenRequest struct {
+	ConsumerKey string `json:"consumer_key"`
+	RedirectURI string `json:"redirect_uri"`
+}
+
+type requestTokenResult struct {
+	Code string `json:"code"`
+}
+
+type authorizeRequest struct {
+	ConsumerKey string `json:"consumer_key"`
+	Code        string `json:"code"`
+}
+
+type authorizeResult struct {
+	AccessToken string `json:"access_token"`
+	Username    string `json:"username"`
+}
+
+// RequestToken obtains a request token, to be authorized by the
+// user, for the application identified by consumerKey.
+func RequestToken(consumerKey, redirectURI string) (string, error) {
+	return requestToken(context.Background(), consumerKey, redirectURI)
+}
+
+func requestToken(ctx context.Context, consumerKey, redirectURI string) (string, error) {
+	res := &requestTokenResult{}
+	req := &requestTokenRequest{ConsumerKey: consumerKey, RedirectURI: redirectURI}
+	if err := postJSON(ctx, "/v3/oauth/request", req, res); err != nil {
+		return "", fmt.Errorf("cannot obtain Pocket request token: %w", err)
+	}
+	return res.Code, nil
+}
+
+// AuthorizationURL returns the page where the user grants access to
+// the request token code, being redirected to redirectURI afterwards.
+func AuthorizationURL(code, redirectURI string) string {
+	v := url.Values{
+		"request_token": {code},
+		"redirect_uri":  {redirectURI},
+	}
+	return Host + "/auth/authorize?" + v.Encode()
+}
+
+// Authorize converts an authorized request token into an access
+// token, returning the credentials along with the Pocket user name.
+func Authorize(consumerKey, code string) (*Auth, string, error) {
+	return authorize(context.Background(), consumerKey, code)
+}
+
+func authorize(ctx context.Context, consumerKey, code string) (*Auth, string, error) {
+	res := &authorizeResult{}
+	req := &authorizeRequest{ConsumerKey: consumerKey, Code: code}
+	if err := postJSON(ctx, "/v3/oauth/authorize", req, res); err != nil {
+		return nil, "", fmt.Errorf("cannot authorize Pocket access: %w", err)
+	}
+	return &Auth{ConsumerKey: consumerKey, AccessToken: res.AccessToken}, res.Username, nil
+}
+
+// Authenticate runs the whole OAuth flow: it listens on addr for the
+// redirect, calls prompt with the URL the user has to visit, and
+// waits up to timeout for the access to be granted.
+func Authenticate(consumerKey, addr string, prompt func(authURL string), timeout time.Duration) (*Auth, string, error) {
+	return AuthenticateContext(context.Background(), consumerKey, addr, prompt, timeout)
+}
+
+// AuthenticateContext is Authenticate, giving up once ctx is done.
+func AuthenticateContext(ctx context.Context, consumerKey, addr string, prompt func(authURL string), timeout time.Duration) (*Auth, string, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, "", fmt.Errorf("cannot listen for authorization callback: %w", err)
+	}
+	defer l.Close()
+	redirectURI := "http://" + l.Addr().String() + "/callback"
+	code, err := requestToken(ctx, consumerKey, redirectURI)
+	if err != nil {
+		return nil, "", err
+	}
+	called := make(chan bool, 1)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/callback", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "Pocket authorization received, you can close this window.")
+		select {
+		case called <- true:
+		default:
+		}
+	})
+	srv := &http.Server{Handler: mux}
+	go srv.Serve(l)
+	defer srv.Close()
+	prompt(AuthorizationURL(code, redirectURI))
+	select {
+	case <-called:
+	case <-time.After(timeout):
+		return nil, "", ErrTimeout
+	case <-ctx.Done():
+		return nil, "", ctx.Err()
+	}
+	return authorize(ctx, consumerKey, code)
+}
@@ -1,6 +1,7 @@
 package pocket
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/url"
@@ -22,7 +23,12 @@ type retrieveOptions struct {
 	Offset      int64  `json:"offset,omitempty"`
 }
 
-type item struct {
+type Tag struct {
+	ItemID int    `json:"item_id,string"`
+	Tag    string `json:"tag"`
+}
+
+type Item struct {
 	ItemID        int    `json:"item_id,string"`
 	ResolvedID    int    `json:"resolved_id,string"`
 	GivenURL      string `json:"given_url"`
@@ -32,6 +38,26 @@ type item struct {
 	Favorite      string `json:"favorite"`
 	Status        string `json:"status"`
 	SortID        int    `json:"sort_id"`
+	TimeAdded     int64  `json:"time_added,string"`
+	// Tags are only provided with Complete detail
+	Tags map[string]Tag `json:"tags,omitempty"`
+}
+
+func (i *Item) URL() (*url.URL, error) {
+	itemURL := i.ResolvedURL
+	if len(itemURL) <= 0 {
+		itemURL = i.GivenURL
+	}
+	return url.Parse(itemURL)
+}
+
+func (i *Item) IsFavorite() bool {
+	return i.Favorite == "1"
+}
+
+func (i *Item) HasTag(tag string) bool {
+	_, ok := i.Tags[tag]
+	return ok
 }
 
 func NewRetrieveOptions(opts ...RetrieveOpt) *retrieveOptions {
@@ -60,6 +86,11 @@ func WithTag(tag string) RetrieveOpt {
 	}
 }
 
+// Complete requests all item details, tags included.
+func Complete(c *retrieveOptions) {
+	c.DetailType = "complete"
+}
+
 func Unread(c *retrieveOptions) {
 	c.State = "unread"
 }
@@ -82,11 +113,11 @@ type RetrieveResultMeta struct {
 }
 
 type RetrieveResultItems struct {
-	Items []item `json:"list"`
+	Items []Item `json:"list"`
 }
 
 type apiRetrieveResultItems struct {
-	Items map[string]item `json:"list"`
+	Items map[string]Item `json:"list"`
 }
 
 type apiRetrieveResult struct {
@@ -124,7 +155,7 @@ func (r *apiRetrieveResult) UnmarshalJSON(data []byte) error {
 	case []interface{}:
 		if len(dv) == 0 {
 			// Case 1
-			r.Items = make(map[string]item) // enforce an empty result set
+			r.Items = make(map[string]Item) // enforce an empty result set
 			return nil
 		}
 	}
@@ -133,14 +164,19 @@ func (r *apiRetrieveResult) UnmarshalJSON(data []byte) error {
 }
 
 func (a *Auth) Retrieve(conf *retrieveOptions) (*RetrieveResult, error) {
+	return a.RetrieveContext(context.Background(), conf)
+}
+
+// RetrieveContext is Retrieve, aborting the request once ctx is done.
+func (a *Auth) RetrieveContext(ctx context.Context, conf *retrieveOptions) (*RetrieveResult, error) {
 	args := retrievePayload{a, conf}
 	res := &apiRetrieveResult{}
-	err := postJSON("/v3/get", args, &res)
+	err := postJSON(ctx, "/v3/get", args, &res)
 	if err != nil {
 		return nil, err
 	}
 	// Unpack and sort results
-	items := []item{}
+	items := []Item{}
 	for _, v := range res.Items {
 		items = append(items, v)
 	}
@@ -159,40 +195,87 @@ func (a *Auth) Retrieve(conf *retrieveOptions) (*RetrieveResult, error) {
 	return ret, nil
 }
 
+// Tail retrieves items on every tick, emitting their URLs, until
+// done is signaled. Retrieval errors are emitted as well.
 func (a *Auth) Tail(conf *retrieveOptions, tick <-chan time.Time, done <-chan bool) <-chan interface{} {
+	return a.TailContext(doneContext(done), conf, tick)
+}
+
+// TailContext is Tail, stopping once ctx is done, in-flight requests
+// included.
+func (a *Auth) TailContext(ctx context.Context, conf *retrieveOptions, tick <-chan time.Time) <-chan interface{} {
+	out := make(chan interface{}, 1)
+	go func() {
+		defer close(out)
+		for v := range a.TailItemsContext(ctx, conf, tick) {
+			switch v := v.(type) {
+			case *Item:
+				// Already checked
+				u, _ := v.URL()
+				out <- u
+			case *RetrieveResultMeta:
+				// Not part of the URL stream
+			default:
+				out <- v
+			}
+		}
+	}()
+	return out
+}
+
+// TailItems is Tail, emitting whole items instead of their URLs,
+// followed by the metadata of each retrieval, holding the since
+// timestamp to resume from.
+func (a *Auth) TailItems(conf *retrieveOptions, tick <-chan time.Time, done <-chan bool) <-chan interface{} {
+	return a.TailItemsContext(doneContext(done), conf, tick)
+}
+
+// TailItemsContext is TailItems, stopping once ctx is done, in-flight
+// requests included.
+func (a *Auth) TailItemsContext(ctx context.Context, conf *retrieveOptions, tick <-chan time.Time) <-chan interface{} {
 	out := make(chan interface{}, 1)
 	go func() {
 		defer close(out)
 		for {
 			select {
-			case <-done:
+			case <-ctx.Done():
 				return
 			case <-tick:
-				res, err := a.Retrieve(conf)
+				res, err := a.RetrieveContext(ctx, conf)
+				if ctx.Err() != nil {
+					return
+				}
 				if err != nil {
 					out <- err
 					continue
 				}
 				conf.Since = res.Since + 1
-				for _, item := range res.Items {
-					itemURL := item.ResolvedURL
-					if len(itemURL) <= 0 {
-						itemURL = item.GivenURL
-					}
-					itemResult, err := url.Parse(itemURL)
-					if err != nil {
+				for i := range res.Items {
+					item := &res.Items[i]
+					if _, err := item.URL(); err != nil {
 						out <- err
 						continue
 					}
-					out <- itemResult
+					out <- item
 				}
+				out <- &res.RetrieveResultMeta
 			}
 		}
 	}()
 	return out
 }
 
-type itemList []item
+// doneContext returns a context cancelled once done is signaled.
+func doneContext(done <-chan bool) context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-done
+		cancel()
+	}()
+	return ctx
+}
+
+type itemList []Item
 
 func (s itemList) Len() int           { return len(s) }
 func (s itemList) Less(i, j int) bool { return s[i].SortID < s[j].SortID }
@@ -0,0 +1,119 @@
+package pocket
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/nazavode/rm"
+)
+
+const SourceName = "pocket"
+
+// Source emits unread Pocket items as rm items.
+type Source struct {
+	Auth *Auth
+	// Tag restricts retrieval to items tagged with it; when empty,
+	// all unread items are retrieved along with their tags
+	Tag string
+	// Retag, when not empty, replaces the Untag tags of items
+	// once uploaded
+	Retag string
+	Untag []string
+	// Archive items once uploaded
+	Archive bool
+}
+
+func (s *Source) Name() string {
+	return SourceName
+}
+
+// Tail retrieves items on every tick. Cursors are the Pocket
+// since timestamps.
+func (s *Source) Tail(ctx context.Context, cursor rm.Cursor, tick <-chan time.Time) <-chan interface{} {
+	opts := NewRetrieveOptions(Unread)
+	if len(s.Tag) > 0 {
+		WithTag(s.Tag)(opts)
+	} else {
+		Complete(opts)
+	}
+	if since, err := strconv.ParseInt(string(cursor), 10, 64); err == nil && since > 0 {
+		Since(since + 1)(opts)
+	}
+	out := make(chan interface{}, 1)
+	go func() {
+		defer close(out)
+		for v := range s.Auth.TailItemsContext(ctx, opts, tick) {
+			switch v := v.(type) {
+			case *Item:
+				item, err := s.item(v)
+				if err != nil {
+					out <- err
+					continue
+				}
+				out <- item
+			case *RetrieveResultMeta:
+				out <- rm.Cursor(strconv.FormatInt(v.Since, 10))
+			default:
+				out <- v
+			}
+		}
+	}()
+	return out
+}
+
+func (s *Source) Resume(ctx context.Context, id string, u *url.URL) (*rm.Item, error) {
+	itemID, err := strconv.Atoi(id)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Pocket item ID %q", id)
+	}
+	return &rm.Item{ID: id, URL: u, Ack: s.ack(itemID)}, nil
+}
+
+func (s *Source) item(i *Item) (*rm.Item, error) {
+	u, err := i.URL()
+	if err != nil {
+		return nil, err
+	}
+	title := i.ResolvedTitle
+	if len(title) <= 0 {
+		title = i.GivenTitle
+	}
+	tags := make([]string, 0, len(i.Tags))
+	for tag := range i.Tags {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	return &rm.Item{
+		ID:       strconv.Itoa(i.ItemID),
+		URL:      u,
+		Title:    title,
+		Tags:     tags,
+		Favorite: i.IsFavorite(),
+		Added:    time.Unix(i.TimeAdded, 0),
+		Ack:      s.ack(i.ItemID),
+	}, nil
+}
+
+func (s *Source) ack(itemID int) func(context.Context, error) error {
+	return func(ctx context.Context, err error) error {
+		if err != nil {
+			// Leave failed items alone, rmd retries them on restart
+			return nil
+		}
+		actions := []Action{}
+		if len(s.Retag) > 0 {
+			if len(s.Untag) > 0 {
+				actions = append(actions, TagsRemove(itemID, s.Untag...))
+			}
+			actions = append(actions, TagsAdd(itemID, s.Retag))
+		}
+		if s.Archive {
+			actions = append(actions, Archive(itemID))
+		}
+		return s.Auth.ModifyContext(ctx, actions...)
+	}
+}
@@ -0,0 +1,67 @@
+package rm
+
+import (
+	"context"
+	"net/url"
+	"time"
+)
+
+// Item is an entry to be synced, as emitted by a Source.
+type Item struct {
+	// ID identifies the item within its source
+	ID       string
+	URL      *url.URL
+	Title    string
+	Tags     []string
+	Favorite bool
+	Added    time.Time
+	// Document, when not nil, is used as is instead of
+	// retrieving URL
+	Document Document
+	// File, when not empty, is the path of a local PDF or EPUB
+	// file, uploaded as is instead of retrieving and converting
+	// the item
+	File string
+	// Ack is called once the item has been processed, err being
+	// nil on success; it may be nil
+	Ack func(ctx context.Context, err error) error
+}
+
+func (i *Item) HasTag(tag string) bool {
+	for _, t := range i.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// Acknowledge notifies the item source about the outcome of
+// processing the item.
+func (i *Item) Acknowledge(err error) error {
+	return i.AcknowledgeContext(context.Background(), err)
+}
+
+// AcknowledgeContext is Acknowledge, giving up on notifying the
+// source once ctx is done.
+func (i *Item) AcknowledgeContext(ctx context.Context, err error) error {
+	if i.Ack == nil {
+		return nil
+	}
+	return i.Ack(ctx, err)
+}
+
+// Cursor marks how far a Source got; passing the last emitted
+// Cursor to Tail resumes from there.
+type Cursor string
+
+// Source produces items to be synced.
+type Source interface {
+	// Name identifies the source, e.g. in persisted sync state
+	Name() string
+	// Tail polls the source on every tick, starting from cursor,
+	// until ctx is done. It emits *Item, Cursor and error values.
+	Tail(ctx context.Context, cursor Cursor, tick <-chan time.Time) <-chan interface{}
+	// Resume rebuilds an item left pending by a previous run.
+	Resume(ctx context.Context, id string, u *url.URL) (*Item, error)
+}
@@ -0,0 +1,173 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"time"
+)
+
+const fileName = "state.json"
+
+type Status string
+
+const (
+	Pending  Status = "pending"
+	Uploaded Status = "uploaded"
+	Failed   Status = "failed"
+)
+
+type Item struct {
+	Source     string `json:"source"`
+	ID         string `json:"id"`
+	URL        string `json:"url,omitempty"`
+	Slug       string `json:"slug,omitempty"`
+	DocumentID string `json:"document_id,omitempty"`
+	Dest       string `json:"dest,omitempty"`
+	Status     Status `json:"status"`
+	Error      string `json:"error,omitempty"`
+	// Attempts counts the failed attempts at processing the item
+	Attempts int       `json:"attempts,omitempty"`
+	Created  time.Time `json:"created"`
+	Updated  time.Time `json:"updated"`
+}
+
+type data struct {
+	Cursors map[string]string `json:"cursors,omitempty"`
+	Items   map[string]*Item  `json:"items"`
+}
+
+func key(source, id string) string {
+	return source + ":" + id
+}
+
+// Store keeps track of the sync progress. A Store opened without a
+// directory lives in memory only and is lost on exit.
+type Store struct {
+	mu   sync.Mutex
+	path string
+	data data
+}
+
+func Open(dir string) (*Store, error) {
+	s := &Store{data: data{Cursors: make(map[string]string), Items: make(map[string]*Item)}}
+	if len(dir) <= 0 {
+		return s, nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("cannot create state directory %s: %w", dir, err)
+	}
+	s.path = path.Join(dir, fileName)
+	content, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("cannot read state file %s: %w", s.path, err)
+	}
+	if err := json.Unmarshal(content, &s.data); err != nil {
+		return nil, fmt.Errorf("cannot parse state file %s: %w", s.path, err)
+	}
+	if s.data.Cursors == nil {
+		s.data.Cursors = make(map[string]string)
+	}
+	if s.data.Items == nil {
+		s.data.Items = make(map[string]*Item)
+	}
+	return s, nil
+}
+
+// Cursor returns the last position recorded for source.
+func (s *Store) Cursor(source string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.Cursors[source]
+}
+
+func (s *Store) SetCursor(source, cursor string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Cursors[source] = cursor
+	return s.save()
+}
+
+func (s *Store) Get(source, id string) (Item, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	item, ok := s.data.Items[key(source, id)]
+	if !ok {
+		return Item{}, false
+	}
+	return *item, true
+}
+
+// ByDocumentID returns the item uploaded as the reMarkable
+// document identified by documentID.
+func (s *Store) ByDocumentID(documentID string) (Item, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, item := range s.data.Items {
+		if item.DocumentID == documentID {
+			return *item, true
+		}
+	}
+	return Item{}, false
+}
+
+// Update applies f to the item identified by source and id,
+// creating it if it doesn't exist yet, and persists the result.
+func (s *Store) Update(source, id string, f func(*Item)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now().UTC()
+	item, ok := s.data.Items[key(source, id)]
+	if !ok {
+		item = &Item{Source: source, ID: id, Status: Pending, Created: now}
+		s.data.Items[key(source, id)] = item
+	}
+	f(item)
+	item.Updated = now
+	return s.save()
+}
+
+func (s *Store) Items() []Item {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	items := make([]Item, 0, len(s.data.Items))
+	for _, item := range s.data.Items {
+		items = append(items, *item)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Created.Before(items[j].Created)
+	})
+	return items
+}
+
+func (s *Store) save() error {
+	if len(s.path) <= 0 {
+		return nil
+	}
+	content, err := json.MarshalIndent(&s.data, "", "  ")
+	if err != nil {
+		return fmt.Errorf("cannot marshal state: %w", err)
+	}
+	tmp, err := ioutil.TempFile(path.Dir(s.path), fileName+".*")
+	if err != nil {
+		return fmt.Errorf("cannot create temporary state file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return fmt.Errorf("cannot write temporary state file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("cannot write temporary state file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("cannot replace state file %s: %w", s.path, err)
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package rm
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+
+	rmApi "github.com/juruen/rmapi/api"
+	rmModel "github.com/juruen/rmapi/model"
+	rmTransport "github.com/juruen/rmapi/transport"
+)
+
+const (
+	// refreshMargin is how long before expiry user tokens are renewed
+	refreshMargin = 10 * time.Minute
+	// refreshBackoff is how long to wait after a failed renewal
+	refreshBackoff = time.Minute
+)
+
+// tokenExpiry returns the expiry time encoded in the exp claim of a
+// JWT, or the zero time if it can't be decoded.
+func tokenExpiry(token string) time.Time {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return time.Time{}
+	}
+	claims := struct {
+		Exp int64 `json:"exp"`
+	}{}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Exp <= 0 {
+		return time.Time{}
+	}
+	return time.Unix(claims.Exp, 0)
+}
+
+// api returns the current API context, renewing the user token
+// first if it's about to expire. Calls made while the token is being
+// renewed go on with the current one, still valid.
+func (s *Connection) api() *rmApi.ApiCtx {
+	s.mu.RLock()
+	apiCtx, due := s.apiCtx, s.refreshDue()
+	s.mu.RUnlock()
+	if !due {
+		return apiCtx
+	}
+	s.mu.Lock()
+	// Someone else might have started renewing it meanwhile
+	if !s.refreshDue() {
+		apiCtx = s.apiCtx
+		s.mu.Unlock()
+		return apiCtx
+	}
+	s.refreshing = true
+	s.mu.Unlock()
+	return s.refresh()
+}
+
+func (s *Connection) refreshDue() bool {
+	now := time.Now()
+	return !s.refreshing && !s.expiry.IsZero() && now.After(s.expiry.Add(-refreshMargin)) && now.After(s.retryAt)
+}
+
+// refresh renews the user token, swapping the API context for one
+// sharing the same file tree. It must be called with s.refreshing
+// set; s.mu is only held to swap the context.
+func (s *Connection) refresh() *rmApi.ApiCtx {
+	defer func() {
+		s.mu.Lock()
+		s.refreshing = false
+		s.mu.Unlock()
+	}()
+	userToken, err := NewUserToken(s.deviceToken)
+	s.mu.Lock()
+	if err != nil {
+		s.retryAt = time.Now().Add(refreshBackoff)
+	} else {
+		transport := rmTransport.CreateHttpClientCtx(rmModel.AuthTokens{
+			DeviceToken: s.deviceToken,
+			UserToken:   userToken,
+		})
+		s.apiCtx = &rmApi.ApiCtx{Http: &transport, Filetree: s.apiCtx.Filetree}
+		s.expiry = tokenExpiry(userToken)
+	}
+	apiCtx := s.apiCtx
+	s.mu.Unlock()
+	if s.onRefresh != nil {
+		s.onRefresh(userToken, err)
+	}
+	return apiCtx
+}
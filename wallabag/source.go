@@ -0,0 +1,232 @@
+package wallabag
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nazavode/rm"
+)
+
+const SourceName = "wallabag"
+
+// Source emits unread Wallabag entries as rm items.
+type Source struct {
+	Client *Client
+	// Tags restricts retrieval to entries tagged with all of them
+	Tags    []string
+	Starred bool
+	// FullContent uses the content already extracted by Wallabag
+	// instead of retrieving the entry page
+	FullContent bool
+	// Retag, when not empty, replaces the Untag tags of entries
+	// once uploaded
+	Retag string
+	Untag []string
+	// Archive entries once uploaded
+	Archive bool
+}
+
+func (s *Source) Name() string {
+	return SourceName
+}
+
+func (s *Source) query(since int64) *Query {
+	unread := false
+	q := &Query{Archived: &unread, Tags: s.Tags}
+	if s.Starred {
+		q.Starred = &s.Starred
+	}
+	if since > 0 {
+		q.Since = time.Unix(since, 0)
+	}
+	return q
+}
+
+// cursor is how far Tail got: the update time of the most recent
+// entries seen, along with their IDs, since Wallabag's since filter
+// includes entries updated at that very second.
+type cursor struct {
+	since int64
+	seen  map[int]bool
+}
+
+// parseCursor decodes cursors formatted as the timestamp followed by
+// a colon and the comma separated IDs.
+func parseCursor(c rm.Cursor) *cursor {
+	cur := &cursor{seen: make(map[int]bool)}
+	fields := strings.SplitN(string(c), ":", 2)
+	cur.since, _ = strconv.ParseInt(fields[0], 10, 64)
+	if len(fields) > 1 {
+		for _, id := range strings.Split(fields[1], ",") {
+			if n, err := strconv.Atoi(id); err == nil {
+				cur.seen[n] = true
+			}
+		}
+	}
+	return cur
+}
+
+func (c *cursor) cursor() rm.Cursor {
+	ids := make([]int, 0, len(c.seen))
+	for id := range c.seen {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	fields := make([]string, len(ids))
+	for i, id := range ids {
+		fields[i] = strconv.Itoa(id)
+	}
+	return rm.Cursor(strconv.FormatInt(c.since, 10) + ":" + strings.Join(fields, ","))
+}
+
+// advance moves the cursor past e, returning false if e was already
+// seen.
+func (c *cursor) advance(e *Entry) bool {
+	if e.Updated().IsZero() {
+		// Can't tell, better twice than never
+		return true
+	}
+	updated := e.Updated().Unix()
+	switch {
+	case updated < c.since:
+		return false
+	case updated == c.since:
+		if c.seen[e.ID] {
+			return false
+		}
+	default:
+		c.since = updated
+		c.seen = make(map[int]bool)
+	}
+	c.seen[e.ID] = true
+	return true
+}
+
+// Tail retrieves entries on every tick, emitting each of them once
+// per update.
+func (s *Source) Tail(ctx context.Context, cur rm.Cursor, tick <-chan time.Time) <-chan interface{} {
+	c := parseCursor(cur)
+	out := make(chan interface{}, 1)
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-tick:
+				entries, err := s.Client.EntriesContext(ctx, s.query(c.since))
+				if ctx.Err() != nil {
+					return
+				}
+				if err != nil {
+					out <- err
+					continue
+				}
+				for i := range entries {
+					e := &entries[i]
+					if !c.advance(e) {
+						continue
+					}
+					item, err := s.item(e)
+					if err != nil {
+						out <- err
+						continue
+					}
+					out <- item
+				}
+				out <- c.cursor()
+			}
+		}
+	}()
+	return out
+}
+
+func (s *Source) Resume(ctx context.Context, id string, u *url.URL) (*rm.Item, error) {
+	entryID, err := strconv.Atoi(id)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Wallabag entry ID %q", id)
+	}
+	e, err := s.Client.EntryContext(ctx, entryID)
+	if err != nil {
+		return nil, err
+	}
+	return s.item(e)
+}
+
+func (s *Source) item(e *Entry) (*rm.Item, error) {
+	u, err := url.Parse(e.URL)
+	if err != nil || len(e.URL) <= 0 {
+		return nil, fmt.Errorf("wallabag entry %d has no valid URL", e.ID)
+	}
+	tags := make([]string, 0, len(e.Tags))
+	for _, t := range e.Tags {
+		tags = append(tags, t.Label)
+	}
+	item := &rm.Item{
+		ID:       strconv.Itoa(e.ID),
+		URL:      u,
+		Title:    e.Title,
+		Tags:     tags,
+		Favorite: bool(e.Starred),
+		Added:    e.Created(),
+		Ack:      s.ack(e.ID),
+	}
+	if s.FullContent && len(e.Content) > 0 {
+		item.Document = rm.NewHTMLDocument(u, e.Title, e.Content, rm.Metadata{
+			Author:    strings.Join(e.PublishedBy, ", "),
+			SiteName:  e.DomainName,
+			Language:  e.Language,
+			Published: e.Published(),
+		})
+	}
+	return item, nil
+}
+
+func (s *Source) ack(entryID int) func(context.Context, error) error {
+	return func(ctx context.Context, err error) error {
+		if err != nil {
+			// Leave failed entries alone, rmd retries them on restart
+			return nil
+		}
+		if len(s.Retag) > 0 {
+			if err := s.untag(ctx, entryID); err != nil {
+				return err
+			}
+			if err := s.Client.AddTagsContext(ctx, entryID, s.Retag); err != nil {
+				return err
+			}
+		}
+		if s.Archive {
+			return s.Client.ArchiveContext(ctx, entryID)
+		}
+		return nil
+	}
+}
+
+// untag removes the Untag tags from an entry. Tags are removed by
+// ID, so the entry is fetched again to get its current ones.
+func (s *Source) untag(ctx context.Context, entryID int) error {
+	if len(s.Untag) <= 0 {
+		return nil
+	}
+	e, err := s.Client.EntryContext(ctx, entryID)
+	if err != nil {
+		return err
+	}
+	for _, t := range e.Tags {
+		for _, label := range s.Untag {
+			if strings.EqualFold(t.Label, label) {
+				if err := s.Client.RemoveTagContext(ctx, entryID, t.ID); err != nil {
+					return err
+				}
+				break
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,318 @@
+// Package wallabag implements a minimal client for the Wallabag API
+// (https://doc.wallabag.org/en/developer/api/readme.html).
+package wallabag
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Client talks to a Wallabag instance, authenticating through the
+// OAuth2 password grant.
+type Client struct {
+	// BaseURL is the instance root, e.g. https://app.wallabag.it
+	BaseURL      string
+	ClientID     string
+	ClientSecret string
+	Username     string
+	Password     string
+	Timeout      time.Duration
+
+	mu      sync.Mutex
+	access  string
+	refresh string
+	expiry  time.Time
+}
+
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+// flag decodes Wallabag booleans, sent either as 0/1 or true/false
+type flag bool
+
+func (f *flag) UnmarshalJSON(data []byte) error {
+	switch strings.Trim(string(data), `"`) {
+	case "1", "true":
+		*f = true
+	case "0", "false", "null":
+		*f = false
+	default:
+		return fmt.Errorf("invalid boolean %s", data)
+	}
+	return nil
+}
+
+type Tag struct {
+	ID    int    `json:"id"`
+	Label string `json:"label"`
+	Slug  string `json:"slug"`
+}
+
+type Entry struct {
+	ID          int      `json:"id"`
+	URL         string   `json:"url"`
+	Title       string   `json:"title"`
+	Content     string   `json:"content"`
+	Language    string   `json:"language"`
+	DomainName  string   `json:"domain_name"`
+	PublishedBy []string `json:"published_by"`
+	PublishedAt string   `json:"published_at"`
+	CreatedAt   string   `json:"created_at"`
+	UpdatedAt   string   `json:"updated_at"`
+	Archived    flag     `json:"is_archived"`
+	Starred     flag     `json:"is_starred"`
+	Tags        []Tag    `json:"tags"`
+}
+
+const dateLayout = "2006-01-02T15:04:05-0700"
+
+func parseDate(value string) time.Time {
+	for _, layout := range []string{dateLayout, time.RFC3339} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func (e *Entry) Created() time.Time {
+	return parseDate(e.CreatedAt)
+}
+
+func (e *Entry) Updated() time.Time {
+	return parseDate(e.UpdatedAt)
+}
+
+func (e *Entry) Published() time.Time {
+	return parseDate(e.PublishedAt)
+}
+
+// Query filters the entries to retrieve; zero values match all.
+type Query struct {
+	Archived *bool
+	Starred  *bool
+	// Tags matches entries having all of them
+	Tags []string
+	// Since matches entries updated after it
+	Since time.Time
+	// PerPage is the page size, 30 if not set
+	PerPage int
+}
+
+func (q *Query) values(page int) url.Values {
+	v := url.Values{}
+	boolean := func(key string, b *bool) {
+		if b == nil {
+			return
+		}
+		if *b {
+			v.Set(key, "1")
+		} else {
+			v.Set(key, "0")
+		}
+	}
+	boolean("archive", q.Archived)
+	boolean("starred", q.Starred)
+	if len(q.Tags) > 0 {
+		v.Set("tags", strings.Join(q.Tags, ","))
+	}
+	if !q.Since.IsZero() {
+		v.Set("since", strconv.FormatInt(q.Since.Unix(), 10))
+	}
+	perPage := q.PerPage
+	if perPage <= 0 {
+		perPage = 30
+	}
+	v.Set("perPage", strconv.Itoa(perPage))
+	v.Set("page", strconv.Itoa(page))
+	v.Set("sort", "updated")
+	v.Set("order", "asc")
+	v.Set("detail", "full")
+	return v
+}
+
+type entriesResponse struct {
+	Page     int `json:"page"`
+	Pages    int `json:"pages"`
+	Embedded struct {
+		Items []Entry `json:"items"`
+	} `json:"_embedded"`
+}
+
+func (c *Client) endpoint(p string) string {
+	return strings.TrimRight(c.BaseURL, "/") + p
+}
+
+func (c *Client) httpClient() *http.Client {
+	return &http.Client{Timeout: c.Timeout}
+}
+
+func decode(resp *http.Response, res interface{}) error {
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("got response %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	if res == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(res)
+}
+
+// token returns a valid access token, authenticating or
+// refreshing the current one as needed.
+func (c *Client) token(ctx context.Context, force bool) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !force && len(c.access) > 0 && time.Now().Add(30*time.Second).Before(c.expiry) {
+		return c.access, nil
+	}
+	if len(c.refresh) > 0 {
+		err := c.grant(ctx, url.Values{
+			"grant_type":    {"refresh_token"},
+			"refresh_token": {c.refresh},
+		})
+		if err == nil {
+			return c.access, nil
+		}
+		// Refresh tokens expire too, start over
+		c.refresh = ""
+	}
+	err := c.grant(ctx, url.Values{
+		"grant_type": {"password"},
+		"username":   {c.Username},
+		"password":   {c.Password},
+	})
+	if err != nil {
+		return "", fmt.Errorf("wallabag authentication failed: %w", err)
+	}
+	return c.access, nil
+}
+
+func (c *Client) grant(ctx context.Context, form url.Values) error {
+	form.Set("client_id", c.ClientID)
+	form.Set("client_secret", c.ClientSecret)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint("/oauth/v2/token"), strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	res := &tokenResponse{}
+	if err := decode(resp, res); err != nil {
+		return err
+	}
+	c.access = res.AccessToken
+	c.refresh = res.RefreshToken
+	c.expiry = time.Now().Add(time.Duration(res.ExpiresIn) * time.Second)
+	return nil
+}
+
+// do performs an authenticated request, retrying once with a
+// fresh token if the current one is rejected.
+func (c *Client) do(ctx context.Context, method, p string, form url.Values, res interface{}) error {
+	for attempt := 0; ; attempt++ {
+		token, err := c.token(ctx, attempt > 0)
+		if err != nil {
+			return err
+		}
+		var body io.Reader
+		target := c.endpoint(p)
+		if method == http.MethodGet {
+			target += "?" + form.Encode()
+		} else if form != nil {
+			body = strings.NewReader(form.Encode())
+		}
+		req, err := http.NewRequestWithContext(ctx, method, target, body)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		if body != nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		resp, err := c.httpClient().Do(req)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode == http.StatusUnauthorized && attempt == 0 {
+			resp.Body.Close()
+			continue
+		}
+		if err := decode(resp, res); err != nil {
+			return fmt.Errorf("wallabag %s %s: %w", method, p, err)
+		}
+		return nil
+	}
+}
+
+// Entries returns all the entries matching q, walking every page.
+func (c *Client) Entries(q *Query) ([]Entry, error) {
+	return c.EntriesContext(context.Background(), q)
+}
+
+// EntriesContext is Entries, aborting requests once ctx is done.
+func (c *Client) EntriesContext(ctx context.Context, q *Query) ([]Entry, error) {
+	entries := []Entry{}
+	for page := 1; ; page++ {
+		res := &entriesResponse{}
+		if err := c.do(ctx, http.MethodGet, "/api/entries.json", q.values(page), res); err != nil {
+			return nil, err
+		}
+		entries = append(entries, res.Embedded.Items...)
+		if page >= res.Pages {
+			return entries, nil
+		}
+	}
+}
+
+func (c *Client) Archive(id int) error {
+	return c.ArchiveContext(context.Background(), id)
+}
+
+func (c *Client) ArchiveContext(ctx context.Context, id int) error {
+	return c.do(ctx, http.MethodPatch, fmt.Sprintf("/api/entries/%d.json", id), url.Values{"archive": {"1"}}, nil)
+}
+
+func (c *Client) AddTags(id int, tags ...string) error {
+	return c.AddTagsContext(context.Background(), id, tags...)
+}
+
+func (c *Client) AddTagsContext(ctx context.Context, id int, tags ...string) error {
+	return c.do(ctx, http.MethodPatch, fmt.Sprintf("/api/entries/%d.json", id), url.Values{"tags": {strings.Join(tags, ",")}}, nil)
+}
+
+func (c *Client) RemoveTag(id, tagID int) error {
+	return c.RemoveTagContext(context.Background(), id, tagID)
+}
+
+func (c *Client) RemoveTagContext(ctx context.Context, id, tagID int) error {
+	return c.do(ctx, http.MethodDelete, fmt.Sprintf("/api/entries/%d/tags/%d.json", id, tagID), nil, nil)
+}
+
+func (c *Client) Entry(id int) (*Entry, error) {
+	return c.EntryContext(context.Background(), id)
+}
+
+func (c *Client) EntryContext(ctx context.Context, id int) (*Entry, error) {
+	res := &Entry{}
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("/api/entries/%d.json", id), url.Values{}, res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
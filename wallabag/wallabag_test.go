@@ -0,0 +1,255 @@
+package wallabag
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nazavode/rm"
+)
+
+// fakeServer mimics the Wallabag API endpoints used by Client.
+type fakeServer struct {
+	t       *testing.T
+	mu      sync.Mutex
+	entries []Entry
+	grants  []string
+	access  string
+	// reject makes the next API call fail with 401
+	reject bool
+	calls  []string
+}
+
+func newFakeServer(t *testing.T, entries []Entry) (*fakeServer, *Client) {
+	f := &fakeServer{t: t, entries: entries}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return f, &Client{
+		BaseURL:      srv.URL + "/",
+		ClientID:     "id",
+		ClientSecret: "secret",
+		Username:     "user",
+		Password:     "password",
+		Timeout:      5 * time.Second,
+	}
+}
+
+func (f *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.URL.Path == "/oauth/v2/token" {
+		f.token(w, r)
+		return
+	}
+	if r.Header.Get("Authorization") != "Bearer "+f.access || f.reject {
+		f.reject = false
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		f.t.Error(err)
+	}
+	f.calls = append(f.calls, r.Method+" "+r.URL.Path+" "+r.PostForm.Encode())
+	switch {
+	case r.URL.Path == "/api/entries.json":
+		f.list(w, r)
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/api/entries/"):
+		id, _ := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/entries/"), ".json"))
+		for _, e := range f.entries {
+			if e.ID == id {
+				json.NewEncoder(w).Encode(e)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+	default:
+		fmt.Fprint(w, "{}")
+	}
+}
+
+func (f *fakeServer) token(w http.ResponseWriter, r *http.Request) {
+	if r.PostFormValue("client_id") != "id" || r.PostFormValue("client_secret") != "secret" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	grant := r.PostFormValue("grant_type")
+	switch grant {
+	case "password":
+		if r.PostFormValue("username") != "user" || r.PostFormValue("password") != "password" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	case "refresh_token":
+		if r.PostFormValue("refresh_token") != "refresh" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+	f.grants = append(f.grants, grant)
+	f.access = fmt.Sprintf("access%d", len(f.grants))
+	json.NewEncoder(w).Encode(tokenResponse{AccessToken: f.access, RefreshToken: "refresh", ExpiresIn: 3600})
+}
+
+func (f *fakeServer) list(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	perPage, _ := strconv.Atoi(q.Get("perPage"))
+	page, _ := strconv.Atoi(q.Get("page"))
+	since, _ := strconv.ParseInt(q.Get("since"), 10, 64)
+	matching := []Entry{}
+	for _, e := range f.entries {
+		if e.Updated().Unix() >= since {
+			matching = append(matching, e)
+		}
+	}
+	res := entriesResponse{Page: page, Pages: (len(matching) + perPage - 1) / perPage}
+	if res.Pages == 0 {
+		res.Pages = 1
+	}
+	start, end := (page-1)*perPage, page*perPage
+	if end > len(matching) {
+		end = len(matching)
+	}
+	if start < end {
+		res.Embedded.Items = matching[start:end]
+	}
+	json.NewEncoder(w).Encode(res)
+}
+
+func entry(id int, updated time.Time) Entry {
+	return Entry{
+		ID:        id,
+		URL:       fmt.Sprintf("https://example.com/%d", id),
+		Title:     fmt.Sprintf("Entry %d", id),
+		UpdatedAt: updated.Format(dateLayout),
+		Tags:      []Tag{{ID: 10, Label: "rm"}, {ID: 11, Label: "other"}},
+	}
+}
+
+func TestTokenGrant(t *testing.T) {
+	f, c := newFakeServer(t, []Entry{entry(1, time.Now())})
+	if _, err := c.Entry(1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Entry(1); err != nil {
+		t.Fatal(err)
+	}
+	// A rejected token is refreshed and the call retried
+	f.reject = true
+	if _, err := c.Entry(1); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"password", "refresh_token"}
+	if strings.Join(f.grants, ",") != strings.Join(want, ",") {
+		t.Errorf("got grants %v, want %v", f.grants, want)
+	}
+}
+
+func TestTokenGrantFailure(t *testing.T) {
+	_, c := newFakeServer(t, nil)
+	c.Password = "wrong"
+	if _, err := c.Entry(1); err == nil || !strings.Contains(err.Error(), "authentication failed") {
+		t.Errorf("got error %v, want authentication failure", err)
+	}
+}
+
+func TestEntriesPaging(t *testing.T) {
+	base := time.Now().Add(-time.Hour).Truncate(time.Second)
+	entries := []Entry{}
+	for i := 1; i <= 7; i++ {
+		entries = append(entries, entry(i, base.Add(time.Duration(i)*time.Second)))
+	}
+	f, c := newFakeServer(t, entries)
+	got, err := c.Entries(&Query{PerPage: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 7 {
+		t.Fatalf("got %d entries, want 7", len(got))
+	}
+	for i, e := range got {
+		if e.ID != i+1 {
+			t.Errorf("entry %d has ID %d", i, e.ID)
+		}
+	}
+	if len(f.calls) != 3 {
+		t.Errorf("got %d calls, want 3", len(f.calls))
+	}
+}
+
+func TestAck(t *testing.T) {
+	f, c := newFakeServer(t, []Entry{entry(1, time.Now())})
+	s := &Source{Client: c, Retag: "done", Untag: []string{"RM"}, Archive: true}
+	item, err := s.item(&f.entries[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := item.Acknowledge(nil); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"GET /api/entries/1.json ",
+		"DELETE /api/entries/1/tags/10.json ",
+		"PATCH /api/entries/1.json tags=done",
+		"PATCH /api/entries/1.json archive=1",
+	}
+	if strings.Join(f.calls, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got calls:\n%s\nwant:\n%s", strings.Join(f.calls, "\n"), strings.Join(want, "\n"))
+	}
+	// Failed items are left alone
+	f.calls = nil
+	if err := item.Acknowledge(fmt.Errorf("failure")); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.calls) > 0 {
+		t.Errorf("got calls %v for a failed item", f.calls)
+	}
+}
+
+func tail(t *testing.T, s *Source, cursor rm.Cursor) ([]string, rm.Cursor) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tick := make(chan time.Time, 1)
+	tick <- time.Now()
+	ids := []string{}
+	for v := range s.Tail(ctx, cursor, tick) {
+		switch v := v.(type) {
+		case *rm.Item:
+			ids = append(ids, v.ID)
+		case rm.Cursor:
+			return ids, v
+		case error:
+			t.Fatal(v)
+		}
+	}
+	t.Fatal("tail ended without a cursor")
+	return nil, ""
+}
+
+func TestTailBoundary(t *testing.T) {
+	base := time.Now().Add(-time.Hour).Truncate(time.Second)
+	f, c := newFakeServer(t, []Entry{entry(1, base), entry(2, base.Add(time.Second))})
+	s := &Source{Client: c}
+	ids, cursor := tail(t, s, "")
+	if strings.Join(ids, ",") != "1,2" {
+		t.Errorf("got entries %v, want 1,2", ids)
+	}
+	// Entries at the cursor second are emitted once, later ones again
+	f.mu.Lock()
+	f.entries = append(f.entries, entry(3, base.Add(time.Second)))
+	f.mu.Unlock()
+	ids, cursor = tail(t, s, cursor)
+	if strings.Join(ids, ",") != "3" {
+		t.Errorf("got entries %v, want 3", ids)
+	}
+	ids, _ = tail(t, s, cursor)
+	if len(ids) > 0 {
+		t.Errorf("got entries %v, want none", ids)
+	}
+}